@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// sample rate shared by every audio backend
+const sampleRate = 44100
+
+// audio backend, fed one 60Hz frame at a time
+type audio interface {
+	frame(on bool) error // queue one frame of sound, tone while on
+	close() error
+}
+
+// tone shapes for the beeper
+type waveform int
+
+const (
+	square waveform = iota
+	triangle
+	sawtooth
+	sine
+)
+
+func parseWaveform(s string) (waveform, error) {
+	switch s {
+	case "square":
+		return square, nil
+	case "triangle":
+		return triangle, nil
+	case "sawtooth":
+		return sawtooth, nil
+	case "sine":
+		return sine, nil
+	}
+	return square, fmt.Errorf("unknown waveform %q", s)
+}
+
+// beeper sounding while the sound timer is non-zero
+type tone struct {
+	freq   float64  // pitch in Hz
+	volume float64  // 0 to 1
+	wave   waveform // shape of a single period
+	phase  float64  // position within the current period, 0 to 1
+}
+
+func newTone(freq, volume float64, wave waveform) *tone {
+	return &tone{freq: freq, volume: volume, wave: wave}
+}
+
+// next n samples, silence when off
+// (the phase keeps running so the tone never clicks mid-period)
+func (t *tone) samples(n int, on bool) []int16 {
+	out := make([]int16, n)
+	amp := t.volume * math.MaxInt16
+	for k := range out {
+		if on {
+			var s float64
+			switch t.wave {
+			case square:
+				s = 1
+				if t.phase >= 0.5 {
+					s = -1
+				}
+			case triangle:
+				s = 4*math.Abs(t.phase-0.5) - 1
+			case sawtooth:
+				s = 2*t.phase - 1
+			case sine:
+				s = math.Sin(2 * math.Pi * t.phase)
+			}
+			out[k] = int16(s * amp)
+		}
+		t.phase += t.freq / sampleRate
+		t.phase -= math.Floor(t.phase)
+	}
+	return out
+}
+
+// samples in a single 60Hz frame
+func frameSamples() int {
+	return sampleRate / 60
+}
+
+// discard all sound
+type nullAudio struct{}
+
+func (nullAudio) frame(on bool) error { return nil }
+func (nullAudio) close() error        { return nil }
+
+// play sound through an SDL queued audio device
+type sdlAudio struct {
+	dev  sdl.AudioDeviceID
+	tone *tone
+}
+
+func newSDLAudio(t *tone) (*sdlAudio, error) {
+	spec := &sdl.AudioSpec{
+		Freq:     sampleRate,
+		Format:   sdl.AUDIO_S16SYS,
+		Channels: 1,
+		Samples:  512,
+	}
+	dev, err := sdl.OpenAudioDevice("", false, spec, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	sdl.PauseAudioDevice(dev, false)
+	return &sdlAudio{dev: dev, tone: t}, nil
+}
+
+func (a *sdlAudio) frame(on bool) error {
+	samples := a.tone.samples(frameSamples(), on)
+	if !on {
+		return nil
+	}
+
+	// don't let the queue run ahead of the emulator by more than a few frames
+	if sdl.GetQueuedAudioSize(a.dev) > uint32(4*2*frameSamples()) {
+		return nil
+	}
+
+	buf := make([]byte, 2*len(samples))
+	for k, s := range samples {
+		binary.LittleEndian.PutUint16(buf[2*k:], uint16(s))
+	}
+	return sdl.QueueAudio(a.dev, buf)
+}
+
+func (a *sdlAudio) close() error {
+	sdl.CloseAudioDevice(a.dev)
+	return nil
+}
+
+// capture sound to a 16-bit mono PCM WAV file
+type wavAudio struct {
+	w    io.WriteSeeker
+	tone *tone
+	size uint32 // bytes of sample data written so far
+}
+
+func newWAVAudio(path string, t *tone) (*wavAudio, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &wavAudio{w: f, tone: t}
+
+	// sizes are patched in on close
+	if err := a.header(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// write the RIFF header for the data written so far
+func (a *wavAudio) header() error {
+	h := struct {
+		Riff          [4]byte
+		RiffSize      uint32
+		Wave          [4]byte
+		Fmt           [4]byte
+		FmtSize       uint32
+		Format        uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Data          [4]byte
+		DataSize      uint32
+	}{
+		Riff:          [4]byte{'R', 'I', 'F', 'F'},
+		RiffSize:      36 + a.size,
+		Wave:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		Format:        1, // PCM
+		Channels:      1,
+		SampleRate:    sampleRate,
+		ByteRate:      sampleRate * 2,
+		BlockAlign:    2,
+		BitsPerSample: 16,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      a.size,
+	}
+	if _, err := a.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return binary.Write(a.w, binary.LittleEndian, &h)
+}
+
+func (a *wavAudio) frame(on bool) error {
+	samples := a.tone.samples(frameSamples(), on)
+	a.size += uint32(2 * len(samples))
+	return binary.Write(a.w, binary.LittleEndian, samples)
+}
+
+func (a *wavAudio) close() error {
+	err := a.header()
+	if c, ok := a.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
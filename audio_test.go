@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestToneSquare(t *testing.T) {
+	// 11025Hz is exactly four samples per period
+	tn := newTone(sampleRate/4, 0.5, square)
+	got := tn.samples(8, true)
+	amp := 0.5 * 32767
+	hi, lo := int16(amp), int16(-amp)
+	expected := []int16{hi, hi, lo, lo, hi, hi, lo, lo}
+	for k := range expected {
+		if got[k] != expected[k] {
+			t.Fatalf("fatal square wave error: expected %d, got %d at sample %d", expected[k], got[k], k)
+		}
+	}
+
+	for k, s := range tn.samples(8, false) {
+		if s != 0 {
+			t.Fatalf("fatal silence error: expected 0, got %d at sample %d", s, k)
+		}
+	}
+}
+
+func TestWAVAudio(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.wav")
+	a, err := newWAVAudio(path, newTone(440, 1, square))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, on := range []bool{true, false, true} {
+		if err := a.frame(on); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataSize := 3 * 2 * frameSamples()
+	if len(data) != 44+dataSize {
+		t.Fatalf("fatal wav length error: expected %d bytes, got %d", 44+dataSize, len(data))
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" || string(data[36:40]) != "data" {
+		t.Fatalf("fatal wav header error: %q", data[:44])
+	}
+	if got := binary.LittleEndian.Uint32(data[40:44]); got != uint32(dataSize) {
+		t.Fatalf("fatal wav data size error: expected %d, got %d", dataSize, got)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// one headless machine to run
+type batchJob struct {
+	rom     string
+	program []byte
+	seed    int64
+}
+
+// what became of a machine
+type batchResult struct {
+	ROM          string `json:"rom"`
+	Seed         int64  `json:"seed"`
+	SHA1         string `json:"sha1"`         // of the rom
+	Frames       int    `json:"frames"`       // completed frames
+	Instructions int    `json:"instructions"` // executed instructions
+	State        string `json:"state"`        // sha1 of the final machine state
+	Coverage     int    `json:"coverage"`     // distinct instruction addresses executed
+	Stuck        bool   `json:"stuck"`        // a frame changed nothing, it would loop forever
+	Blank        bool   `json:"blank"`        // nothing on screen at the end
+	Trap         string `json:"trap"`         // program fault, if any
+	Error        string `json:"error"`        // anything else that stopped the run
+}
+
+var batchColumns = []string{"rom", "seed", "sha1", "frames", "instructions", "state", "coverage", "stuck", "blank", "trap", "error"}
+
+func (r batchResult) row() []string {
+	return []string{
+		r.ROM,
+		strconv.FormatInt(r.Seed, 10),
+		r.SHA1,
+		strconv.Itoa(r.Frames),
+		strconv.Itoa(r.Instructions),
+		r.State,
+		strconv.Itoa(r.Coverage),
+		strconv.FormatBool(r.Stuck),
+		strconv.FormatBool(r.Blank),
+		r.Trap,
+		r.Error,
+	}
+}
+
+// settings shared by every machine in a batch
+type batchConfig struct {
+	frames int
+	ipf    int
+	quirks quirks
+	jit    bool // run through the basic block recompiler
+}
+
+// run one machine for the configured frames, or until it stops
+func (cfg batchConfig) run(job batchJob) (res batchResult) {
+	res = batchResult{ROM: job.rom, Seed: job.seed, SHA1: romHash(job.program)}
+	c := &cpu{
+		rng:           rand.New(rand.NewSource(job.seed)),
+		quirks:        cfg.quirks,
+		waitKeyPlugin: headlessWaitKey,
+	}
+	if cfg.jit {
+		c.jit = newRecompiler()
+	}
+
+	defer func() {
+		res.State = c.snapshot().hash()
+		res.Blank = c.disp == [32][64]uint8{}
+		if c.prof != nil {
+			res.Coverage = c.prof.coverage()
+		}
+	}()
+
+	if err := c.init(job.program); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	c.prof = newProfile(c.layout.entry)
+	prev := c.snapshot()
+	for res.Frames < cfg.frames {
+		n, err := c.run(cfg.ipf)
+		res.Instructions += n
+		if t, ok := err.(*trap); ok {
+			res.Trap = t.Error()
+			return res
+		}
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.Frames++
+
+		// without input or running timers the same state comes round forever
+		s := c.snapshot()
+		if s == prev && c.dt == 0 && c.st == 0 {
+			res.Stuck = true
+			return res
+		}
+		prev = s
+	}
+	return res
+}
+
+// run every job across a pool of workers, results in job order
+func (cfg batchConfig) runAll(jobs []batchJob, workers int) []batchResult {
+	results := make([]batchResult, len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range next {
+				results[k] = cfg.run(jobs[k])
+			}
+		}()
+	}
+	for k := range jobs {
+		next <- k
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+func writeBatchReport(w io.Writer, format string, results []batchResult) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write(batchColumns)
+		for _, r := range results {
+			out.Write(r.row())
+		}
+		out.Flush()
+		return out.Error()
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+// chip8 batch [-seeds n] [-frames n] [-workers n] [-format json|csv] [-o file] rom...
+func batchCmd(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	seeds := fs.Int("seeds", 1, "runs per rom, seeded 1 to n")
+	frames := fs.Int("frames", 600, "60Hz frames to run each machine for")
+	ipf := fs.Int("ipf", defaultIPF, "instructions per 60Hz frame")
+	workers := fs.Int("workers", runtime.NumCPU(), "machines run at once")
+	quirksName := fs.String("quirks", "chip8", "platform quirks: "+strings.Join(quirkPresetNames(), ", "))
+	format := fs.String("format", "json", "report format: json or csv")
+	outPath := fs.String("o", "", "report file, stdout if empty")
+	jit := fs.Bool("jit", false, "run through the basic block recompiler, reporting no coverage")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("batch: no roms")
+	}
+	if *seeds < 1 || *frames < 1 || *ipf < 1 || *workers < 1 {
+		return fmt.Errorf("batch: seeds, frames, ipf and workers must be positive")
+	}
+	q, err := parseQuirks(*quirksName)
+	if err != nil {
+		return err
+	}
+
+	var jobs []batchJob
+	for _, path := range fs.Args() {
+		r, err := loadROM(path, nil)
+		if err != nil {
+			return err
+		}
+		program := r.data
+		for seed := int64(1); seed <= int64(*seeds); seed++ {
+			jobs = append(jobs, batchJob{rom: path, program: program, seed: seed})
+		}
+	}
+
+	// keep instruction traces out of the run
+	log.SetOutput(ioutil.Discard)
+
+	cfg := batchConfig{frames: *frames, ipf: *ipf, quirks: q, jit: *jit}
+	results := cfg.runAll(jobs, *workers)
+
+	if *outPath == "" {
+		return writeBatchReport(os.Stdout, *format, results)
+	}
+	f, err := os.Create(*outPath)
+	if err != nil {
+		return err
+	}
+	if err := writeBatchReport(f, *format, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	pong, err := ioutil.ReadFile("pong.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := []batchJob{
+		{"pong.ch8", pong, 1},
+		{"pong.ch8", pong, 2},
+		{"pong.ch8", pong, 1},
+		{"unknown", []byte{0x00, 0x01}, 1},
+		{"recurse", []byte{0x22, 0x00}, 1},
+		{"return", []byte{0x00, 0xEE}, 1},
+		{"huge", make([]byte, 4096), 1},
+	}
+	cfg := batchConfig{frames: 120, ipf: defaultIPF}
+	results := cfg.runAll(jobs, 3)
+
+	for k, r := range results {
+		if r.ROM != jobs[k].rom || r.Seed != jobs[k].seed {
+			t.Fatalf("fatal batch error for job %d: results out of order, got %s seed %d", k, r.ROM, r.Seed)
+		}
+	}
+	if results[0].Frames != 120 || results[0].Trap != "" || results[0].Error != "" || results[0].Coverage == 0 {
+		t.Fatalf("fatal batch error for pong: expected 120 clean frames with coverage, got %+v", results[0])
+	}
+	if results[0].State != results[2].State {
+		t.Fatalf("fatal batch error for pong: expected the same seed to end in the same state")
+	}
+	if results[0].State == results[1].State {
+		t.Fatalf("fatal batch error for pong: expected different seeds to end in different states")
+	}
+
+	traps := map[string]string{"unknown": "unknown opcode", "recurse": "stack overflow", "return": "stack underflow"}
+	for _, r := range results[3:6] {
+		if !strings.Contains(r.Trap, traps[r.ROM]) || r.Frames >= cfg.frames {
+			t.Fatalf("fatal batch error for %s: expected a %s trap, got %+v", r.ROM, traps[r.ROM], r)
+		}
+	}
+	if results[6].Error == "" {
+		t.Fatalf("fatal batch error for huge: expected the rom not to fit")
+	}
+
+	// the recompiler ends runs the same way
+	cfg.jit = true
+	jitJobs := append([]batchJob{}, jobs[3:6]...)
+	jitJobs = append(jitJobs, batchJob{"runaway", []byte{0xBF, 0xFF}, 1})
+	traps["runaway"] = "pc out of range"
+	for _, r := range cfg.runAll(jitJobs, 3) {
+		if !strings.Contains(r.Trap, traps[r.ROM]) {
+			t.Fatalf("fatal batch error for %s with jit: expected a %s trap, got %+v", r.ROM, traps[r.ROM], r)
+		}
+	}
+	if r := cfg.run(batchJob{"pong.ch8", pong, 1}); r.Frames != 120 || r.Trap != "" || r.Error != "" {
+		t.Fatalf("fatal batch error for pong with jit: expected 120 clean frames, got %+v", r)
+	}
+
+	out := &bytes.Buffer{}
+	if err := writeBatchReport(out, "csv", results); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(results)+1 || len(rows[0]) != len(batchColumns) {
+		t.Fatalf("fatal batch error for csv: expected %d rows of %d columns, got %d", len(results)+1, len(batchColumns), len(rows))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// instructions executed per 60Hz frame
+const defaultIPF = 5
+
+// answer every key wait with key 0 so headless runs never block
+func headlessWaitKey() (uint8, bool) {
+	return 0x0, true
+}
+
+// result of a headless benchmark run
+type benchResult struct {
+	instructions int
+	elapsed      time.Duration
+	err          error
+}
+
+func (r benchResult) ips() float64 {
+	return float64(r.instructions) / r.elapsed.Seconds()
+}
+
+func (r benchResult) fps(ipf int) float64 {
+	return r.ips() / float64(ipf)
+}
+
+// run a program headlessly for roughly d and count executed instructions
+func benchRun(program []byte, d time.Duration, jit bool) benchResult {
+	c := &cpu{
+		rng:           rand.New(rand.NewSource(1)),
+		waitKeyPlugin: headlessWaitKey,
+	}
+	if jit {
+		c.jit = newRecompiler()
+	}
+	res := benchResult{}
+	if err := c.init(program); err != nil {
+		res.err = err
+		return res
+	}
+
+	start := time.Now()
+	for time.Since(start) < d {
+		n, err := c.run(10000)
+		res.instructions += n
+		if err != nil {
+			res.err = err
+			break
+		}
+	}
+	res.elapsed = time.Since(start)
+	return res
+}
+
+// chip8 bench [-seconds n] [-ipf n] [-jit] [rom...]
+func benchCmd(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	seconds := fs.Float64("seconds", 1, "how long to run each rom")
+	ipf := fs.Int("ipf", defaultIPF, "instructions per emulated 60Hz frame")
+	jit := fs.Bool("jit", false, "run through the basic block recompiler")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *ipf <= 0 {
+		return fmt.Errorf("bench: ipf must be positive, got %d", *ipf)
+	}
+
+	roms := fs.Args()
+	if len(roms) == 0 {
+		roms = []string{"pong.ch8"}
+	}
+
+	// keep instruction traces out of the measurement
+	log.SetOutput(ioutil.Discard)
+
+	mode := "interpreter"
+	if *jit {
+		mode = "recompiler"
+	}
+	fmt.Printf("%-24s %-12s %14s %12s\n", "rom", "mode", "ips", "fps")
+	for _, path := range roms {
+		r, err := loadROM(path, nil)
+		if err != nil {
+			return err
+		}
+		program := r.data
+		res := benchRun(program, time.Duration(*seconds*float64(time.Second)), *jit)
+		fmt.Printf("%-24s %-12s %14.0f %12.0f\n", path, mode, res.ips(), res.fps(*ipf))
+		if res.err != nil {
+			fmt.Printf("  stopped after %d instructions: %s\n", res.instructions, res.err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+// one representative opcode per family
+var familyOpcodes = []struct {
+	name   string
+	opcode uint16
+}{
+	{"00E0", 0x00E0},
+	{"1NNN", 0x1200},
+	{"3XKK", 0x3012},
+	{"6XKK", 0x6012},
+	{"7XKK", 0x7012},
+	{"8XY4", 0x8014},
+	{"9XY0", 0x9010},
+	{"ANNN", 0xA300},
+	{"CXKK", 0xC0FF},
+	{"DXYN", 0xD015},
+	{"EX9E", 0xE09E},
+	{"FX1E", 0xF01E},
+	{"FX33", 0xF033},
+	{"FX55", 0xF355},
+	{"FX65", 0xF365},
+}
+
+func BenchmarkFetch(b *testing.B) {
+	c := &cpu{}
+	for n := 0; n < b.N; n++ {
+		c.fetch()
+		c.pc &= 0x0FFE
+	}
+}
+
+func BenchmarkExecFamily(b *testing.B) {
+	for _, f := range familyOpcodes {
+		b.Run(f.name, func(b *testing.B) {
+			c := &cpu{i: 0x300}
+			for n := 0; n < b.N; n++ {
+				c.pc = 0x200
+				c.exec(f.opcode)
+			}
+		})
+	}
+}
+
+func BenchmarkFrame(b *testing.B) {
+	program, err := ioutil.ReadFile("pong.ch8")
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := &cpu{waitKeyPlugin: headlessWaitKey, audio: nullAudio{}}
+	c.init(program)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := c.frame(defaultIPF); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPresent(b *testing.B) {
+	disp := mockAllOnDisplay()
+	setCell := func(x, y int, ch rune, fg, bg termbox.Attribute) {}
+	flush := func() error { return nil }
+	for _, mode := range []struct {
+		name string
+		mode termMode
+	}{
+		{"full", termFull},
+		{"half", termHalf},
+		{"braille", termBraille},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			v := &termVideo{mode: mode.mode, setCellPlugin: setCell, flushPlugin: flush}
+			for n := 0; n < b.N; n++ {
+				v.present(&disp, allRows)
+			}
+		})
+	}
+}
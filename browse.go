@@ -0,0 +1,343 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// a rom in the library, with what the database knows of it
+type browseItem struct {
+	path     string // as play takes it, archive.zip#name inside a zip
+	title    string
+	platform string
+	authors  []string
+	keys     []string // key hints, purpose then host keys
+	err      string   // why it would not load, if it did not
+
+	program []byte
+	opts    romOptions
+	thumb   *[32][64]uint8 // display after the preview run, nil until run
+}
+
+// files browse lists, besides the rom extensions
+var browseExtensions = map[string]bool{".gif": true, ".gz": true, ".zip": true}
+
+// every rom under dir, a zip counting once for each rom inside
+func scanLibrary(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if _, ok := romExtensions[ext]; !ok && !browseExtensions[ext] {
+			return nil
+		}
+		if ext != ".zip" {
+			paths = append(paths, path)
+			return nil
+		}
+		z, err := zip.OpenReader(path)
+		if err != nil {
+			paths = append(paths, path) // listed, and the error shown there
+			return nil
+		}
+		defer z.Close()
+		for _, f := range z.File {
+			if _, ok := romExtensions[strings.ToLower(filepath.Ext(f.Name))]; ok {
+				paths = append(paths, path+"#"+f.Name)
+			}
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// load a rom and settle its settings as play would, from the defaults
+// play starts with, and its key hints from the keymap play would use
+func newBrowseItem(path string, db romDB, preset, keymapPath string) browseItem {
+	it := browseItem{path: path, title: filepath.Base(path)}
+	r, err := loadROM(path, nil)
+	if err != nil {
+		it.err = err.Error()
+		return it
+	}
+	it.title = strings.TrimSuffix(r.name, filepath.Ext(r.name))
+	it.platform = r.platform
+
+	q, _ := parseQuirks("chip8")
+	l, _ := parseLayout("chip8")
+	it.opts = romOptions{quirks: q, layout: l, font: "chip8", ipf: defaultIPF, palette: "green", filter: "none"}
+	program, entry, known, err := db.resolve(r, &it.opts, nil)
+	if err != nil {
+		it.err = err.Error()
+		return it
+	}
+	it.program = program
+	if known {
+		it.title = entry.program.Title
+		it.authors = entry.program.Authors
+		if len(entry.info.Platforms) > 0 {
+			it.platform = entry.info.Platforms[0]
+		}
+		km, err := loadKeymap(preset, keymapPath, r.name, romHash(program))
+		if err != nil {
+			it.err = err.Error()
+			return it
+		}
+		it.keys = keyHints(km, entry.info.Keys)
+	}
+	return it
+}
+
+// "purpose: keys" for each key a rom names, bound as play would bind them
+func keyHints(km *keymap, keys map[string]int) []string {
+	if len(keys) == 0 {
+		return nil
+	}
+	hinted := &keymap{keys: map[string]uint8{}, actions: km.actions}
+	for name, k := range km.keys {
+		hinted.keys[name] = k
+	}
+	hinted.hint(keys)
+
+	var hints []string
+	for purpose, k := range keys {
+		var names []string
+		for name, bound := range hinted.keys {
+			if int(bound) == k {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		hints = append(hints, fmt.Sprintf("%s: %s", purpose, strings.Join(names, " ")))
+	}
+	sort.Strings(hints)
+	return hints
+}
+
+// run the rom headless for some frames and keep what it shows
+func (it *browseItem) preview(frames int) {
+	it.thumb = &[32][64]uint8{}
+	if it.program == nil {
+		return
+	}
+	font, err := loadFont(it.opts.font)
+	if err != nil {
+		return
+	}
+	c := &cpu{
+		rng:           rand.New(rand.NewSource(1)),
+		quirks:        it.opts.quirks,
+		layout:        it.opts.layout,
+		font:          font,
+		waitKeyPlugin: headlessWaitKey,
+	}
+
+	// a program that traps shows what it drew before it did
+	if err := c.init(it.program); err != nil {
+		return
+	}
+	for f := 0; f < frames; f++ {
+		if _, err := c.run(it.opts.ipf); err != nil {
+			break
+		}
+	}
+	*it.thumb = c.disp
+}
+
+// list width in cells, the details and thumbnail to its right
+const browseListW = 28
+
+// the library in the terminal, the selected rom previewed
+type browser struct {
+	items    []browseItem
+	selected int
+	top      int                // first item shown
+	frames   int                // preview length
+	out      termbox.OutputMode // thumbnail colours
+
+	// plugins
+	setCellPlugin func(x, y int, r rune, fg, bg termbox.Attribute)
+}
+
+// draw the list and the selected rom, h cells tall
+func (b *browser) draw(h int) {
+	put := func(x, y int, s string, fg, bg termbox.Attribute) {
+		for _, r := range s {
+			b.setCellPlugin(x, y, r, fg, bg)
+			x++
+		}
+	}
+
+	// list, scrolled to keep the selection on screen
+	rows := h - 1
+	if b.selected < b.top {
+		b.top = b.selected
+	}
+	if rows > 0 && b.selected >= b.top+rows {
+		b.top = b.selected - rows + 1
+	}
+	for y := 0; y < rows && b.top+y < len(b.items); y++ {
+		k := b.top + y
+		text := []rune(b.items[k].title)
+		if len(text) > browseListW-2 {
+			text = text[:browseListW-2]
+		}
+		fg := termbox.ColorDefault
+		if k == b.selected {
+			fg |= termbox.AttrReverse
+		}
+		put(0, y, fmt.Sprintf(" %-*s", browseListW-2, string(text)), fg, termbox.ColorDefault)
+	}
+	put(0, h-1, "up/down choose  enter play  escape quit", termbox.ColorDefault, termbox.ColorDefault)
+	if len(b.items) == 0 {
+		return
+	}
+
+	// details
+	it := &b.items[b.selected]
+	x := browseListW + 1
+	lines := []string{it.title, it.path}
+	if it.platform != "" {
+		lines = append(lines, "platform: "+it.platform)
+	}
+	if len(it.authors) > 0 {
+		lines = append(lines, "by "+strings.Join(it.authors, ", "))
+	}
+	lines = append(lines, it.keys...)
+	if it.err != "" {
+		lines = append(lines, it.err)
+	}
+	for y, line := range lines {
+		put(x, y, line, termbox.ColorDefault, termbox.ColorDefault)
+	}
+
+	// thumbnail, in half blocks below the details
+	if it.err != "" {
+		return
+	}
+	if it.thumb == nil {
+		it.preview(b.frames)
+	}
+	p, err := parsePalette(it.opts.palette)
+	if err != nil {
+		p, _ = parsePalette("green")
+	}
+	v := newTermVideo(termHalf, p, b.out)
+	oy := len(lines) + 1
+	v.setCellPlugin = func(cx, cy int, r rune, fg, bg termbox.Attribute) {
+		b.setCellPlugin(x+cx, oy+cy, r, fg, bg)
+	}
+	v.flushPlugin = func() error { return nil }
+	v.present(it.thumb, allRows)
+}
+
+// move the selection by n, staying on the list
+func (b *browser) move(n int) {
+	b.selected += n
+	if b.selected >= len(b.items) {
+		b.selected = len(b.items) - 1
+	}
+	if b.selected < 0 {
+		b.selected = 0
+	}
+}
+
+// show the library until a rom is chosen, returning its index, or -1
+func (b *browser) run() (int, error) {
+	if err := termbox.Init(); err != nil {
+		return -1, err
+	}
+	defer termbox.Close()
+	b.out = termbox.SetOutputMode(detectOutputMode())
+	for {
+		termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+		_, h := termbox.Size()
+		b.draw(h)
+		termbox.Flush()
+
+		ev := termbox.PollEvent()
+		if ev.Type == termbox.EventError {
+			return -1, ev.Err
+		}
+		if ev.Type != termbox.EventKey {
+			continue
+		}
+		switch termboxKeyName(ev) {
+		case "up", "k":
+			b.move(-1)
+		case "down", "j":
+			b.move(1)
+		case "enter":
+			if len(b.items) > 0 && b.items[b.selected].err == "" {
+				return b.selected, nil
+			}
+		case "escape", "q":
+			return -1, nil
+		}
+	}
+}
+
+// chip8 browse [-seconds n] [-romdb file] dir [play flags]
+func browseCmd(args []string) error {
+	fs := flag.NewFlagSet("browse", flag.ContinueOnError)
+	seconds := fs.Float64("seconds", 1, "seconds each preview runs for")
+	romdbPath := fs.String("romdb", defaultROMDBPath(), "json rom database merged over the built in one")
+	preset := fs.String("keys", "qwerty", "keymap preset: "+strings.Join(keymapPresetNames(), ", "))
+	keymapPath := fs.String("keymap", defaultKeymapPath(), "keymap json file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("browse: expected a directory")
+	}
+	if *seconds <= 0 {
+		return fmt.Errorf("browse: seconds must be positive")
+	}
+	db, err := loadROMDB(*romdbPath)
+	if err != nil {
+		return err
+	}
+	paths, err := scanLibrary(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("browse: no roms in %s", fs.Arg(0))
+	}
+
+	// keep instruction traces out of the previews
+	log.SetOutput(ioutil.Discard)
+
+	b := &browser{frames: int(60 * *seconds), setCellPlugin: termbox.SetCell}
+	for _, path := range paths {
+		b.items = append(b.items, newBrowseItem(path, db, *preset, *keymapPath))
+	}
+	k, err := b.run()
+	if err != nil || k < 0 {
+		return err
+	}
+
+	// play settles the settings again, with the flags browse shares
+	var playArgs []string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "seconds" {
+			playArgs = append(playArgs, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	playArgs = append(playArgs, fs.Args()[1:]...)
+	play(append(playArgs, b.items[k].path))
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestBrowse(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pong, err := ioutil.ReadFile("pong.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipped := &bytes.Buffer{}
+	z := zip.NewWriter(zipped)
+	for _, name := range []string{"b.ch8", "readme.txt"} {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte{0x12, 0x00})
+	}
+	z.Close()
+
+	write("pong.ch8", pong)
+	write("more/loop.sc8", []byte{0x12, 0x00})
+	write("games.zip", zipped.Bytes())
+	write("notes.txt", []byte("not a rom"))
+
+	paths, err := scanLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "games.zip") + "#b.ch8",
+		filepath.Join(dir, "more", "loop.sc8"),
+		filepath.Join(dir, "pong.ch8"),
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("fatal browse error: expected %v, got %v", want, paths)
+	}
+
+	// details from the database, or the file
+	db, err := loadROMDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path     string
+		title    string
+		platform string
+		keys     []string
+	}{
+		{want[0], "b", "chip8", nil},
+		{want[1], "loop", "schip", nil},
+		{want[2], "Pong", "modernChip8", []string{"player1Down: down q", "player1Up: 1 up", "player2Down: r", "player2Up: 4"}},
+	}
+	items := make([]browseItem, len(cases))
+	for k, tc := range cases {
+		it := newBrowseItem(tc.path, db, "qwerty", "")
+		if it.err != "" || it.title != tc.title || it.platform != tc.platform || !reflect.DeepEqual(it.keys, tc.keys) {
+			t.Fatalf("fatal browse error for %s: expected %s, %s and %v, got %s, %s and %v (%s)", tc.path, tc.title, tc.platform, tc.keys, it.title, it.platform, it.keys, it.err)
+		}
+		items[k] = it
+	}
+
+	// pong's preview shows its paddles and score, drawn beside the list
+	b := &browser{items: items, selected: 2, frames: 60}
+	cells := map[[2]int]rune{}
+	b.setCellPlugin = func(x, y int, r rune, fg, bg termbox.Attribute) { cells[[2]int{x, y}] = r }
+	b.draw(24)
+	if items[2].thumb == nil || *items[2].thumb == ([32][64]uint8{}) {
+		t.Fatalf("fatal browse error: expected a preview of pong")
+	}
+	title := ""
+	for x := browseListW + 1; x < browseListW+5; x++ {
+		title += string(cells[[2]int{x, 0}])
+	}
+	if title != "Pong" {
+		t.Fatalf("fatal browse error: expected Pong beside the list, got %q", title)
+	}
+	lit := 0
+	for y := 0; y < 24; y++ {
+		for x := browseListW + 1; x < browseListW+65; x++ {
+			if strings.ContainsRune("▀▄█", cells[[2]int{x, y}]) {
+				lit++
+			}
+		}
+	}
+	if lit == 0 {
+		t.Fatalf("fatal browse error: expected the preview drawn")
+	}
+
+	// the selection stays on the list
+	b.move(5)
+	if b.selected != 2 {
+		t.Fatalf("fatal browse error: expected the last rom selected, got %d", b.selected)
+	}
+	b.move(-5)
+	if b.selected != 0 {
+		t.Fatalf("fatal browse error: expected the first rom selected, got %d", b.selected)
+	}
+}
+
+func TestBrowsePreviewTraps(t *testing.T) {
+	it := browseItem{
+		program: []byte{
+			0xD0, 0x15, // 0x200: draw the 0 at 0x000
+			0xAF, 0xFF, // 0x202: i = 0xFFF
+			0xF2, 0x55, // 0x204: store v0-v2 past the end of memory
+		},
+		opts: romOptions{font: "chip8", ipf: defaultIPF, layout: standardLayout},
+	}
+	it.preview(10)
+	if it.thumb == nil || it.thumb[0][0] != 1 {
+		t.Fatalf("fatal browse error: expected what was drawn before the trap")
+	}
+}
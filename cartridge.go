@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// octo cartridges are gif animations of 128x64 frames carrying a program
+// and its options in the two low bits of each pixel's palette index, four
+// pixels to a byte, high bits first, row by row and frame by frame. the
+// bytes are a big endian length and a json payload of that length. the
+// palette holds the four label colours four times over, so every frame
+// shows the label whatever the bits are.
+const (
+	cartWidth  = 128
+	cartHeight = 64
+	cartDelay  = 10 // hundredths of a second each frame shows for
+)
+
+type cartridge struct {
+	Key     string      `json:"key"`
+	Program string      `json:"program"` // octo source
+	Options cartOptions `json:"options"`
+}
+
+// octo's emulator settings
+type cartOptions struct {
+	Tickrate        int    `json:"tickrate"`
+	FillColor       string `json:"fillColor"`
+	FillColor2      string `json:"fillColor2"`
+	BlendColor      string `json:"blendColor"`
+	BackgroundColor string `json:"backgroundColor"`
+	BuzzColor       string `json:"buzzColor"`
+	QuietColor      string `json:"quietColor"`
+	ShiftQuirks     bool   `json:"shiftQuirks"`
+	LoadStoreQuirks bool   `json:"loadStoreQuirks"`
+	VFOrderQuirks   bool   `json:"vfOrderQuirks"`
+	ClipQuirks      bool   `json:"clipQuirks"`
+	JumpQuirks      bool   `json:"jumpQuirks"`
+	VBlankQuirks    bool   `json:"vBlankQuirks"`
+	LogicQuirks     bool   `json:"logicQuirks"`
+	ScreenRotation  int    `json:"screenRotation"`
+	MaxSize         int    `json:"maxSize"`
+	TouchInputMode  string `json:"touchInputMode"`
+	FontStyle       string `json:"fontStyle"`
+}
+
+// octo's default colours
+var defaultCartOptions = cartOptions{
+	Tickrate:        20,
+	FillColor:       "#FFCC00",
+	FillColor2:      "#FF6600",
+	BlendColor:      "#662200",
+	BackgroundColor: "#996600",
+	BuzzColor:       "#FFAA00",
+	QuietColor:      "#000000",
+	MaxSize:         3584,
+	TouchInputMode:  "none",
+	FontStyle:       "octo",
+}
+
+func isCartridge(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))
+}
+
+func decodeCartridge(data []byte) (*cartridge, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cartridge: %s", err)
+	}
+
+	// two bits a pixel, row by row, one frame after another
+	var payload []byte
+	var acc uint8
+	n := 0
+	for _, pm := range g.Image {
+		b := pm.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				acc = acc<<2 | pm.ColorIndexAt(x, y)&3
+				if n++; n%4 == 0 {
+					payload = append(payload, acc)
+				}
+			}
+		}
+	}
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("cartridge: image too small")
+	}
+	size := int(payload[0])<<24 | int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+	if size < 0 || size > len(payload)-4 {
+		return nil, fmt.Errorf("cartridge: payload of %d bytes in an image holding %d", size, len(payload)-4)
+	}
+	cart := &cartridge{Options: defaultCartOptions}
+	if err := json.Unmarshal(payload[4:4+size], cart); err != nil {
+		return nil, fmt.Errorf("cartridge: %s", err)
+	}
+	return cart, nil
+}
+
+// the program's bytes, assembled as octo would
+func (cart *cartridge) assemble() ([]byte, error) {
+	return assembleOcto(cart.Program)
+}
+
+// the options as rom database settings, to apply like them
+func (cart *cartridge) info() romInfo {
+	o := cart.Options
+	info := romInfo{
+		Quirks: map[string]bool{
+			"shift":   !o.ShiftQuirks,
+			"memory":  !o.LoadStoreQuirks,
+			"logic":   o.LogicQuirks,
+			"jump":    o.JumpQuirks,
+			"wrap":    !o.ClipQuirks,
+			"vblank":  o.VBlankQuirks,
+			"vfOrder": o.VFOrderQuirks,
+		},
+		Tickrate:  o.Tickrate,
+		FontStyle: o.FontStyle,
+	}
+	if o.BackgroundColor != "" && o.FillColor != "" {
+		info.Colors = &romColors{Pixels: []string{o.BackgroundColor, o.FillColor}}
+	}
+	return info
+}
+
+// a cartridge for a rom, its source a byte listing
+func newCartridge(program []byte, title string, opts romOptions) (*cartridge, error) {
+	p, err := parsePalette(opts.palette)
+	if err != nil {
+		return nil, err
+	}
+	o := defaultCartOptions
+	o.Tickrate = opts.ipf
+	o.BackgroundColor = fmt.Sprintf("#%06X", p.off)
+	o.FillColor = fmt.Sprintf("#%06X", p.on)
+	o.ShiftQuirks = !opts.quirks.shift
+	o.LoadStoreQuirks = !opts.quirks.memory
+	o.LogicQuirks = opts.quirks.vfReset
+	o.JumpQuirks = opts.quirks.jump
+	o.ClipQuirks = opts.quirks.clip
+	o.VBlankQuirks = opts.quirks.wait
+	o.VFOrderQuirks = !opts.quirks.flagLast
+	if _, ok := fonts[opts.font]; ok {
+		o.FontStyle = opts.font
+	}
+
+	var src strings.Builder
+	fmt.Fprintf(&src, "# %s\n: main\n", title)
+	for k, b := range program {
+		fmt.Fprintf(&src, "0x%02X", b)
+		if k%16 == 15 || k == len(program)-1 {
+			src.WriteByte('\n')
+		} else {
+			src.WriteByte(' ')
+		}
+	}
+	return &cartridge{Program: src.String(), Options: o}, nil
+}
+
+func parseHexColour(s string) color.RGBA {
+	v, _ := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 24)
+	return rgb(uint32(v))
+}
+
+func encodeCartridge(w io.Writer, cart *cartridge) error {
+	payload, err := json.Marshal(cart)
+	if err != nil {
+		return err
+	}
+	size := len(payload)
+	data := append([]byte{uint8(size >> 24), uint8(size >> 16), uint8(size >> 8), uint8(size)}, payload...)
+
+	perFrame := cartWidth * cartHeight / 4
+	frames := (len(data) + perFrame - 1) / perFrame
+
+	// each label colour four times, told apart by the lowest blue bits
+	o := cart.Options
+	var pal color.Palette
+	for _, s := range []string{o.BackgroundColor, o.FillColor, o.FillColor2, o.BlendColor} {
+		c := parseHexColour(s)
+		for bits := uint8(0); bits < 4; bits++ {
+			pal = append(pal, color.RGBA{c.R, c.G, c.B&^3 | bits, 0xFF})
+		}
+	}
+	g := &gif.GIF{Config: image.Config{ColorModel: pal, Width: cartWidth, Height: cartHeight}}
+
+	// a plain label: a border round a darker panel
+	label := func(x, y int) uint8 {
+		switch {
+		case x < 2 || y < 2 || x >= cartWidth-2 || y >= cartHeight-2:
+			return 1
+		case x >= 8 && y >= 8 && x < cartWidth-8 && y < cartHeight-8:
+			return 3
+		}
+		return 0
+	}
+	for f := 0; f < frames; f++ {
+		pm := image.NewPaletted(image.Rect(0, 0, cartWidth, cartHeight), pal)
+		for y := 0; y < cartHeight; y++ {
+			for x := 0; x < cartWidth; x++ {
+				n := f*perFrame*4 + y*cartWidth + x
+				var bits uint8
+				if n/4 < len(data) {
+					bits = data[n/4] >> uint(6-2*(n%4)) & 3
+				}
+				pm.SetColorIndex(x, y, label(x, y)<<2|bits)
+			}
+		}
+		g.Image = append(g.Image, pm)
+		g.Delay = append(g.Delay, cartDelay)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// chip8 cart [-o file.gif] [-quirks name] [-ipf n] [-palette name] [-font name] rom
+func cartCmd(args []string) error {
+	fs := flag.NewFlagSet("cart", flag.ContinueOnError)
+	outPath := fs.String("o", "", "cartridge file, the rom's name with .gif if empty")
+	title := fs.String("title", "", "title in the source, the database title or file name if empty")
+	ipf := fs.Int("ipf", defaultIPF, "instructions per 60Hz frame")
+	quirksName := fs.String("quirks", "chip8", "platform quirks: "+strings.Join(quirkPresetNames(), ", "))
+	paletteName := fs.String("palette", "green", "colours: "+strings.Join(paletteNames(), ", ")+" or RRGGBB:RRGGBB")
+	fontName := fs.String("font", "chip8", "hex font: "+strings.Join(fontNames(), ", "))
+	romdbPath := fs.String("romdb", defaultROMDBPath(), "json rom database merged over the built in one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("cart: expected one rom, got %d", fs.NArg())
+	}
+	path := fs.Arg(0)
+	r, err := loadROM(path, nil)
+	if err != nil {
+		return err
+	}
+	program := r.data
+	q, err := parseQuirks(*quirksName)
+	if err != nil {
+		return err
+	}
+
+	// the database fills in what the flags leave out
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	opts := romOptions{quirks: q, ipf: *ipf, palette: *paletteName, font: *fontName}
+	db, err := loadROMDB(*romdbPath)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(r.name, filepath.Ext(r.name))
+	if entry, ok := db.lookup(program); ok {
+		if err := entry.apply(&opts, set); err != nil {
+			return err
+		}
+		name = entry.program.Title
+	}
+	if *title != "" {
+		name = *title
+	}
+
+	cart, err := newCartridge(program, name, opts)
+	if err != nil {
+		return err
+	}
+	if *outPath == "" {
+		*outPath = filepath.Join(filepath.Dir(path), strings.TrimSuffix(r.name, filepath.Ext(r.name))+".gif")
+	}
+	f, err := os.Create(*outPath)
+	if err != nil {
+		return err
+	}
+	if err := encodeCartridge(f, cart); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+)
+
+func TestCartridge(t *testing.T) {
+	program := []byte{0x00, 0xE0, 0xA2, 0x2A, 0x60, 0x0C, 0x61, 0x08, 0xD0, 0x1F, 0x12, 0x0A}
+	opts := romOptions{quirks: quirkPresets["vip"], ipf: 15, palette: "amber", font: "vip"}
+	cart, err := newCartridge(program, "test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.Options.VFOrderQuirks {
+		t.Fatalf("fatal cartridge error: expected the vip's flag written last, without octo's vF order quirk")
+	}
+	out := &bytes.Buffer{}
+	if err := encodeCartridge(out, cart); err != nil {
+		t.Fatal(err)
+	}
+	if !isCartridge(out.Bytes()) || isCartridge(program) {
+		t.Fatalf("fatal cartridge error: expected only the gif detected")
+	}
+
+	decoded, err := decodeCartridge(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decoded.assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, program) {
+		t.Fatalf("fatal cartridge error: expected program %X, got %X", program, got)
+	}
+
+	// the options come back as the settings they were made from
+	back := romOptions{}
+	if err := (romEntry{info: decoded.info()}).apply(&back, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	if back.quirks != opts.quirks || back.ipf != 15 || back.palette != "000000:FFB000" || back.font != "vip" {
+		t.Fatalf("fatal cartridge error: expected the options back, got %+v", back)
+	}
+}
+
+// every kind of statement the assembler takes, laid out as in octo
+const octoProgram = `
+:alias x v1
+:const speed 3
+: main
+	x := speed
+	i := sprite
+	loop
+		x += -1
+		if x == 0 then return
+		if x > 1 begin
+			draw
+		else
+			v0 := key
+		end
+		while x != 5
+	again
+: draw
+	sprite v0 x 5
+	;
+: sprite
+	:unpack 0xA sprite
+`
+
+func TestCartridgeAssemble(t *testing.T) {
+	cases := []struct {
+		src      string
+		expected []byte
+	}{
+		{": main\n0x00 0xE0 # clear\n0b1000_0001", nil},
+		{": main\n0x00 0xE0 # clear\n0b10000001 255 -1\n: data 7", []byte{0x00, 0xE0, 0x81, 0xFF, 0xFF, 0x07}},
+		{": main\n\tclear\n\tloop again", []byte{0x00, 0xE0, 0x12, 0x02}},
+		{": data 1 2\n: main jump main", []byte{0x12, 0x04, 0x01, 0x02, 0x12, 0x04}},
+		{octoProgram, []byte{
+			0x61, 0x03, 0xA2, 0x22, 0x71, 0xFF, 0x41, 0x00, 0x00, 0xEE,
+			0x6F, 0x01, 0x8F, 0x17, 0x4F, 0x00, 0x12, 0x16, 0x22, 0x1E,
+			0x12, 0x18, 0xF0, 0x0A, 0x41, 0x05, 0x12, 0x1E, 0x12, 0x04,
+			0xD0, 0x15, 0x00, 0xEE, 0xA2, 0x22,
+		}},
+		{": main\n0x100", nil},
+		{": main\n\thires", nil},
+		{": main\n\tloop", nil},
+		{": main\n\tdraw", nil},
+		{": main\n:macro twice x { x x }", nil},
+		{"0x00 0xE0", nil},
+		{"# nothing", nil},
+	}
+	for _, c := range cases {
+		got, err := (&cartridge{Program: c.src}).assemble()
+		if c.expected == nil {
+			if err == nil {
+				t.Fatalf("fatal cartridge error for %q: expected an error, got %X", c.src, got)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, c.expected) {
+			t.Fatalf("fatal cartridge error for %q: expected %X, got %X (%v)", c.src, c.expected, got, err)
+		}
+	}
+	// ordered comparisons, run with octo's flag order, equal operands too
+	for _, op := range []string{"<", ">", "<=", ">="} {
+		for _, n := range []int{4, 5, 6} {
+			for _, rhs := range []string{"5", "v2"} {
+				for _, form := range []string{"if v0 %s %s then v1 := 1", "if v0 %s %s begin v1 := 1 else v1 := 2 end"} {
+					src := fmt.Sprintf(": main\nv0 := %d v2 := 5 v1 := 2\n"+form+"\n: halt jump halt", n, op, rhs)
+					program, err := (&cartridge{Program: src}).assemble()
+					if err != nil {
+						t.Fatal(err)
+					}
+					c := &cpu{quirks: quirks{flagLast: true}}
+					c.init(program)
+					if _, err := c.run(20); err != nil {
+						t.Fatal(err)
+					}
+					holds := map[string]bool{"<": n < 5, ">": n > 5, "<=": n <= 5, ">=": n >= 5}[op]
+					if (c.v[1] == 1) != holds {
+						t.Fatalf("fatal cartridge error for %q: expected %t, got v1 %d", src, holds, c.v[1])
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := decodeCartridge([]byte("GIF89a nonsense")); err == nil {
+		t.Fatalf("fatal cartridge error: expected a broken gif refused")
+	}
+}
+
+// a cartridge laid out as octo writes one, frame by frame, without going
+// through the encoder
+func octoCartridge(t *testing.T, payload []byte) []byte {
+	data := append([]byte{byte(len(payload) >> 24), byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload))}, payload...)
+	var pal color.Palette
+	for k := 0; k < 16; k++ {
+		pal = append(pal, color.Gray{uint8(k * 16)})
+	}
+	g := &gif.GIF{}
+	for start := 0; start < len(data); start += 128 * 64 / 4 {
+		pm := image.NewPaletted(image.Rect(0, 0, 128, 64), pal)
+		for k := range pm.Pix {
+			if n := start + k/4; n < len(data) {
+				pm.Pix[k] = 0x04 | data[n]>>uint(6-2*(k%4))&3
+			}
+		}
+		g.Image = append(g.Image, pm)
+		g.Delay = append(g.Delay, 10)
+	}
+	out := &bytes.Buffer{}
+	if err := gif.EncodeAll(out, g); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestCartridgeFrames(t *testing.T) {
+	// octo's own payload, a program long enough to need three frames
+	src := ": main\n\tv0 := 1\n\tloop again\n" + strings.Repeat("# padding the source out past a frame\n", 150)
+	payload, err := json.Marshal(map[string]interface{}{
+		"key":     "",
+		"program": src,
+		"options": map[string]interface{}{"tickrate": 500, "vfOrderQuirks": false, "shiftQuirks": true, "enableXO": false},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := octoCartridge(t, payload)
+	if g, err := gif.DecodeAll(bytes.NewReader(data)); err != nil || len(g.Image) != 3 {
+		t.Fatalf("fatal cartridge error: expected a fixture of three frames, got %v", err)
+	}
+	cart, err := decodeCartridge(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := cart.assemble()
+	if err != nil || !bytes.Equal(program, []byte{0x60, 0x01, 0x12, 0x02}) {
+		t.Fatalf("fatal cartridge error for octo frames: expected 6001 1202, got %X (%v)", program, err)
+	}
+	if cart.Options.Tickrate != 500 || !cart.Options.ShiftQuirks || cart.Options.VFOrderQuirks {
+		t.Fatalf("fatal cartridge error for octo frames: expected the options back, got %+v", cart.Options)
+	}
+
+	// a large rom is written across 128x64 frames and read back whole
+	big := make([]byte, 3000)
+	for k := range big {
+		big[k] = byte(k * 7)
+	}
+	cart, err = newCartridge(big, "big", romOptions{palette: "green"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := encodeCartridge(out, cart); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) < 2 {
+		t.Fatalf("fatal cartridge error for big: expected several frames, got %d", len(g.Image))
+	}
+	for k, pm := range g.Image {
+		if pm.Bounds() != image.Rect(0, 0, 128, 64) {
+			t.Fatalf("fatal cartridge error for big: expected frame %d 128x64, got %v", k, pm.Bounds())
+		}
+	}
+	decoded, err := decodeCartridge(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decoded.assemble(); err != nil || !bytes.Equal(got, big) {
+		t.Fatalf("fatal cartridge error for big: expected the rom back, got %d bytes (%v)", len(got), err)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a byte a cheat writes, in memory or a v register
+type cheatTarget struct {
+	reg  bool   // v register rather than memory
+	addr uint16 // memory address or register number
+}
+
+// bytes written from a target on
+type patch struct {
+	target cheatTarget
+	values []uint8
+}
+
+// parse codes such as "v3=0A, 2F0=1020": comma separated targets, a hex
+// address or a v register, each given hex bytes written from there on
+func parseCheatCode(code string) ([]patch, error) {
+	var patches []patch
+	for _, part := range strings.Split(code, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || len(kv[1]) == 0 || len(kv[1])%2 != 0 {
+			return nil, fmt.Errorf("cheat: bad code %q", part)
+		}
+		var p patch
+		target := strings.ToLower(kv[0])
+		limit := uint64(0xFFF)
+		if strings.HasPrefix(target, "v") {
+			p.target.reg, target, limit = true, target[1:], 0xF
+		}
+		addr, err := strconv.ParseUint(target, 16, 16)
+		if err != nil || addr > limit {
+			return nil, fmt.Errorf("cheat: bad target %q", kv[0])
+		}
+		p.target.addr = uint16(addr)
+		for k := 0; k < len(kv[1]); k += 2 {
+			b, err := strconv.ParseUint(kv[1][k:k+2], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("cheat: bad value %q", kv[1])
+			}
+			p.values = append(p.values, uint8(b))
+		}
+		if int(addr)+len(p.values) > int(limit)+1 {
+			return nil, fmt.Errorf("cheat: %q runs past the end", part)
+		}
+		patches = append(patches, p)
+	}
+	return patches, nil
+}
+
+// each byte a patch writes, with its value
+func (p patch) bytes() map[cheatTarget]uint8 {
+	out := map[cheatTarget]uint8{}
+	for k, b := range p.values {
+		out[cheatTarget{p.target.reg, p.target.addr + uint16(k)}] = b
+	}
+	return out
+}
+
+func (c *cpu) cheatRead(t cheatTarget) uint8 {
+	if t.reg {
+		return c.v[t.addr]
+	}
+	return c.mem[t.addr]
+}
+
+func (c *cpu) cheatWrite(t cheatTarget, b uint8) {
+	if t.reg {
+		c.v[t.addr] = b
+		return
+	}
+	c.mem[t.addr] = b
+
+	// compiled blocks may hold the old byte
+	if c.jit != nil {
+		c.jit = newRecompiler()
+	}
+}
+
+// patches applied at load and values held every frame, with a memory search
+type cheats struct {
+	load   []patch               // after the rom loads and on each reset
+	frozen map[cheatTarget]uint8 // written at the start of every frame
+	search *ramSearch            // in progress, if any
+}
+
+func newCheats() *cheats {
+	return &cheats{frozen: map[cheatTarget]uint8{}}
+}
+
+// apply the load patches
+func (ch *cheats) patch(c *cpu) {
+	for _, p := range ch.load {
+		for t, b := range p.bytes() {
+			c.cheatWrite(t, b)
+		}
+	}
+}
+
+// write the frozen values back
+func (ch *cheats) hold(c *cpu) {
+	for t, b := range ch.frozen {
+		if c.cheatRead(t) != b {
+			c.cheatWrite(t, b)
+		}
+	}
+}
+
+// freeze a byte at its current value, or let it go if already frozen
+func (ch *cheats) toggle(c *cpu, t cheatTarget) {
+	if _, ok := ch.frozen[t]; ok {
+		delete(ch.frozen, t)
+		return
+	}
+	ch.frozen[t] = c.cheatRead(t)
+}
+
+// cheat file layout
+//
+//	{
+//	  "roms": {
+//	    "pong.ch8": [
+//	      {"name": "ten points", "code": "v3=0A", "when": "load"},
+//	      {"name": "long paddle", "code": "2EA=808080808080", "when": "frame"}
+//	    ]
+//	  }
+//	}
+//
+// roms are looked up by file name or by sha1. load codes are written once
+// the rom is loaded and again on reset, frame codes are held every frame.
+type cheatConfig struct {
+	ROMs map[string][]cheatCode `json:"roms"`
+}
+
+type cheatCode struct {
+	Name string `json:"name"`
+	Code string `json:"code"`
+	When string `json:"when"` // load or frame
+}
+
+// default cheat file, under the user's config directory
+func defaultCheatPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "chip8", "cheats.json")
+}
+
+// cheats for a rom from an optional cheat file
+// (a missing file at the default path is not an error)
+func loadCheats(path, rom, hash string) (*cheats, error) {
+	ch := newCheats()
+	cfg := cheatConfig{}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err) && path == defaultCheatPath():
+		case err != nil:
+			return nil, err
+		default:
+			if err := json.Unmarshal(data, &cfg); err != nil {
+				return nil, fmt.Errorf("cheats %s: %s", path, err)
+			}
+		}
+	}
+
+	codes := append(cfg.ROMs[filepath.Base(rom)], cfg.ROMs[hash]...)
+	for _, code := range codes {
+		patches, err := parseCheatCode(code.Code)
+		if err != nil {
+			return nil, fmt.Errorf("cheats %s: %s: %s", path, code.Name, err)
+		}
+		switch code.When {
+		case "load", "":
+			ch.load = append(ch.load, patches...)
+		case "frame":
+			for _, p := range patches {
+				for t, b := range p.bytes() {
+					ch.frozen[t] = b
+				}
+			}
+		default:
+			return nil, fmt.Errorf("cheats %s: %s: unknown time %q", path, code.Name, code.When)
+		}
+	}
+	return ch, nil
+}
+
+// classic ram search: snapshot memory, then keep the addresses whose
+// values compare the same way at each step
+type ramSearch struct {
+	last       [4096]uint8
+	candidates []uint16 // ascending
+}
+
+func newRAMSearch(c *cpu) *ramSearch {
+	s := &ramSearch{last: c.mem, candidates: make([]uint16, len(c.mem))}
+	for a := range s.candidates {
+		s.candidates[a] = uint16(a)
+	}
+	return s
+}
+
+// keep the candidates whose value is equal, changed, increased or
+// decreased since the last step
+func (s *ramSearch) narrow(c *cpu, how string) error {
+	var keep func(old, now uint8) bool
+	switch how {
+	case "equal":
+		keep = func(old, now uint8) bool { return now == old }
+	case "changed":
+		keep = func(old, now uint8) bool { return now != old }
+	case "increased":
+		keep = func(old, now uint8) bool { return now > old }
+	case "decreased":
+		keep = func(old, now uint8) bool { return now < old }
+	default:
+		return fmt.Errorf("search: unknown comparison %q", how)
+	}
+
+	kept := s.candidates[:0]
+	for _, a := range s.candidates {
+		if keep(s.last[a], c.mem[a]) {
+			kept = append(kept, a)
+		}
+	}
+	s.candidates = kept
+	s.last = c.mem
+	return nil
+}
+
+func (s *ramSearch) has(addr uint16) bool {
+	k := sort.Search(len(s.candidates), func(k int) bool { return s.candidates[k] >= addr })
+	return k < len(s.candidates) && s.candidates[k] == addr
+}
+
+// first candidate after addr, wrapping round
+func (s *ramSearch) next(addr uint16) (uint16, bool) {
+	if len(s.candidates) == 0 {
+		return 0, false
+	}
+	for _, a := range s.candidates {
+		if a > addr {
+			return a, true
+		}
+	}
+	return s.candidates[0], true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCheatCode(t *testing.T) {
+	cases := []struct {
+		code     string
+		expected map[cheatTarget]uint8
+	}{
+		{"v3=0A", map[cheatTarget]uint8{{true, 3}: 0x0A}},
+		{"2F0=1020, vF=01", map[cheatTarget]uint8{{false, 0x2F0}: 0x10, {false, 0x2F1}: 0x20, {true, 0xF}: 0x01}},
+		{"v3=0", nil},
+		{"vG=00", nil},
+		{"FFF=0000", nil},
+		{"200", nil},
+	}
+	for _, c := range cases {
+		patches, err := parseCheatCode(c.code)
+		if c.expected == nil {
+			if err == nil {
+				t.Fatalf("fatal cheat error for %s: expected an error", c.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("fatal cheat error for %s: %s", c.code, err)
+		}
+		got := map[cheatTarget]uint8{}
+		for _, p := range patches {
+			for target, b := range p.bytes() {
+				got[target] = b
+			}
+		}
+		if len(got) != len(c.expected) {
+			t.Fatalf("fatal cheat error for %s: expected %v, got %v", c.code, c.expected, got)
+		}
+		for target, b := range c.expected {
+			if got[target] != b {
+				t.Fatalf("fatal cheat error for %s: expected %v, got %v", c.code, c.expected, got)
+			}
+		}
+	}
+}
+
+func TestCheats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cheats.json")
+	file := `{"roms": {"game.ch8": [
+		{"name": "lives", "code": "v3=09", "when": "load"},
+		{"name": "ammo", "code": "300=63", "when": "frame"}
+	]}}`
+	if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ch, err := loadCheats(path, "other.ch8", "sha"); err != nil || len(ch.load) != 0 || len(ch.frozen) != 0 {
+		t.Fatalf("fatal cheat error: expected no cheats for another rom, got %v", err)
+	}
+	ch, err := loadCheats(path, "roms/game.ch8", "sha")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the program counts v3 and mem[0x300] down
+	program := []byte{
+		0x73, 0xFF, // 0x200: v3 -= 1
+		0xA3, 0x00, // 0x202: i = 0x300
+		0xF0, 0x65, // 0x204: v0 = mem[i]
+		0x70, 0xFF, // 0x206: v0 -= 1
+		0xF0, 0x55, // 0x208: mem[i] = v0
+		0x12, 0x00, // 0x20A: loop
+	}
+	c := &cpu{cheats: ch}
+	if err := c.init(program); err != nil {
+		t.Fatal(err)
+	}
+	ch.patch(c)
+	if err := c.emulate(6); err != nil {
+		t.Fatal(err)
+	}
+	if c.v[3] != 8 || c.mem[0x300] != 0x62 {
+		t.Fatalf("fatal cheat error: expected v3 8 and mem[0x300] 0x62, got %d and 0x%X", c.v[3], c.mem[0x300])
+	}
+	if err := c.emulate(6); err != nil {
+		t.Fatal(err)
+	}
+	if c.v[3] != 7 || c.mem[0x300] != 0x62 {
+		t.Fatalf("fatal cheat error: expected mem[0x300] held, got v3 %d and 0x%X", c.v[3], c.mem[0x300])
+	}
+
+	// the counter is the one that went down twice and then stayed
+	s := newRAMSearch(c)
+	ch.toggle(c, cheatTarget{addr: 0x300})
+	for _, how := range []string{"decreased", "decreased", "equal"} {
+		if how != "equal" {
+			c.emulate(6)
+		}
+		if err := s.narrow(c, how); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.candidates) != 1 || s.candidates[0] != 0x300 || !s.has(0x300) {
+		t.Fatalf("fatal cheat error: expected only 0x300 left, got %X", s.candidates)
+	}
+	if a, ok := s.next(0x400); !ok || a != 0x300 {
+		t.Fatalf("fatal cheat error: expected next to wrap round to 0x300, got 0x%X", a)
+	}
+	if s.narrow(c, "bigger") == nil {
+		t.Fatalf("fatal cheat error: expected an unknown comparison refused")
+	}
+}
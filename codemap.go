@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// a write into code that had already been executed
+type selfMod struct {
+	PC    uint16 `json:"pc"`    // writing instruction
+	Addr  uint16 `json:"addr"`  // code byte overwritten
+	Count int    `json:"count"` // times it happened
+}
+
+// note writes to mem[addr:addr+n] by the instruction at pc,
+// flagging any that land on executed code as they happen
+func (p *profile) written(pc, addr uint16, n int) {
+	for k := 0; k < n; k++ {
+		a := uint16(int(addr)+k) & 0xFFF
+		if p.access[a]&accessExec != 0 {
+			if p.selfMods == nil {
+				p.selfMods = map[[2]uint16]int{}
+			}
+			key := [2]uint16{pc, a}
+			if p.selfMods[key] == 0 && p.selfModPlugin != nil {
+				p.selfModPlugin(pc, a)
+			}
+			p.selfMods[key]++
+		}
+	}
+	p.mark(addr, n, accessWrite)
+}
+
+// writes into executed code, by writing instruction then address
+func (p *profile) selfModified() []selfMod {
+	mods := make([]selfMod, 0, len(p.selfMods))
+	for k, n := range p.selfMods {
+		mods = append(mods, selfMod{k[0], k[1], n})
+	}
+	sortSelfMods(mods)
+	return mods
+}
+
+func sortSelfMods(mods []selfMod) {
+	sort.Slice(mods, func(a, b int) bool {
+		if mods[a].PC != mods[b].PC {
+			return mods[a].PC < mods[b].PC
+		}
+		return mods[a].Addr < mods[b].Addr
+	})
+}
+
+// access bits as saved, one digit per byte
+const accessDigits = "01234567"
+
+// what running a rom showed about its bytes, saved for the disassembler
+type codeMap struct {
+	SHA1    string    `json:"sha1"`              // of the rom
+	Load    uint16    `json:"load"`              // address of the first rom byte
+	Access  string    `json:"access"`            // access bits per rom byte, one digit each
+	SelfMod []selfMod `json:"selfmod,omitempty"` // writes into executed code
+}
+
+// the part of a profile covering a rom loaded at load
+func (p *profile) codeMap(program []byte, load uint16) *codeMap {
+	access := make([]byte, len(program))
+	for k := range access {
+		access[k] = accessDigits[p.access[(int(load)+k)&0xFFF]&7]
+	}
+	m := &codeMap{SHA1: romHash(program), Load: load, Access: string(access)}
+	for _, mod := range p.selfModified() {
+		if int(mod.Addr) >= int(load) && int(mod.Addr) < int(load)+len(program) {
+			m.SelfMod = append(m.SelfMod, mod)
+		}
+	}
+	return m
+}
+
+// access bits of the byte at addr, 0 outside the rom
+func (m *codeMap) bits(addr uint16) uint8 {
+	k := int(addr) - int(m.Load)
+	if k < 0 || k >= len(m.Access) {
+		return 0
+	}
+	return uint8(strings.IndexByte(accessDigits, m.Access[k]))
+}
+
+// fold another run of the same rom into m
+func (m *codeMap) merge(o *codeMap) error {
+	if o.SHA1 != m.SHA1 || o.Load != m.Load || len(o.Access) != len(m.Access) {
+		return fmt.Errorf("codemap: maps of different roms or load addresses")
+	}
+	access := []byte(m.Access)
+	for k := range access {
+		access[k] = accessDigits[m.bits(m.Load+uint16(k))|o.bits(o.Load+uint16(k))]
+	}
+	m.Access = string(access)
+
+	counts := map[[2]uint16]int{}
+	for _, mod := range append(m.SelfMod, o.SelfMod...) {
+		counts[[2]uint16{mod.PC, mod.Addr}] += mod.Count
+	}
+	m.SelfMod = nil
+	for k, n := range counts {
+		m.SelfMod = append(m.SelfMod, selfMod{k[0], k[1], n})
+	}
+	sortSelfMods(m.SelfMod)
+	return nil
+}
+
+// read a saved map, nil if there is none yet
+func loadCodeMap(path string) (*codeMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &codeMap{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("codemap %s: %s", path, err)
+	}
+	for _, r := range m.Access {
+		if !strings.ContainsRune(accessDigits, r) {
+			return nil, fmt.Errorf("codemap %s: bad access bits %q", path, r)
+		}
+	}
+	return m, nil
+}
+
+// fold m into the map saved at path, if any, and save the result
+func saveCodeMap(path string, m *codeMap) error {
+	saved, err := loadCodeMap(path)
+	if err != nil {
+		return err
+	}
+	if saved != nil && saved.SHA1 != m.SHA1 {
+		return fmt.Errorf("codemap %s: saved for rom %s, not %s", path, saved.SHA1, m.SHA1)
+	}
+	if saved != nil {
+		if err := m.merge(saved); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// how the disassembler treats a byte
+const (
+	byteData = iota
+	byteCode
+)
+
+// code or data for each rom byte: what ran is code and what was only
+// read or written is data, the rest goes by what the static control
+// flow graph can reach. m may be nil.
+func classify(mem *[4096]uint8, l layout, size int, m *codeMap) []int {
+	kinds := make([]int, size)
+	g := buildFlowGraph(mem, l, size, nil, nil)
+	for _, b := range g.Blocks {
+		for a := b.Start; a < b.End; a++ {
+			kinds[int(a)-int(l.load)] = byteCode
+		}
+	}
+	if m == nil {
+		return kinds
+	}
+	for k := range kinds {
+		bits := m.bits(l.load + uint16(k))
+		switch {
+		case bits&accessExec != 0:
+			kinds[k] = byteCode
+		case bits != 0:
+			kinds[k] = byteData
+		}
+	}
+	return kinds
+}
+
+// sprite row as pixels
+func pixels(b uint8) string {
+	row := []byte("........")
+	for k := range row {
+		if b&(0x80>>uint(k)) != 0 {
+			row[k] = '#'
+		}
+	}
+	return string(row)
+}
+
+// listing of the rom with code and data separated, noting
+// writes into code from the map if there is one
+func writeDisassembly(w io.Writer, mem *[4096]uint8, l layout, size int, m *codeMap) {
+	kinds := classify(mem, l, size, m)
+	writers := map[uint16][]string{}
+	if m != nil {
+		for _, mod := range m.SelfMod {
+			writers[mod.Addr] = append(writers[mod.Addr], fmt.Sprintf("0x%03X", mod.PC))
+		}
+	}
+	note := func(a uint16) string {
+		var by []string
+		by = append(by, writers[a]...)
+		by = append(by, writers[a+1]...)
+		if len(by) == 0 {
+			return ""
+		}
+		return "  ; modified by " + strings.Join(by, ", ")
+	}
+
+	for k := 0; k < size; {
+		a := l.load + uint16(k)
+		if kinds[k] == byteCode && k+1 < size && kinds[k+1] == byteCode {
+			opcode := uint16(mem[a])<<8 | uint16(mem[a+1])
+			in := dispatch[opcode]
+			fmt.Fprintf(w, "0x%03X  %04X  %-4s  %s%s\n", a, opcode, in.name, in.cPseudo, note(a))
+			k += 2
+			continue
+		}
+		fmt.Fprintf(w, "0x%03X  %02X    data  %s\n", a, mem[a], pixels(mem[a]))
+		k++
+	}
+}
+
+// chip8 disasm [-codemap file] [-layout name] [-o file] rom
+func disasmCmd(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	mapPath := fs.String("codemap", "", "code map saved by a run of the rom, static analysis only if empty")
+	layoutName := fs.String("layout", "chip8", "memory layout: "+strings.Join(layoutNames(), ", "))
+	outPath := fs.String("o", "", "output file, stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("disasm: expected one rom, got %d", fs.NArg())
+	}
+	r, err := loadROM(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	program := r.data
+	l, err := parseLayout(*layoutName)
+	if err != nil {
+		return err
+	}
+	var m *codeMap
+	if *mapPath != "" {
+		if m, err = loadCodeMap(*mapPath); err != nil {
+			return err
+		}
+		if m == nil {
+			return fmt.Errorf("disasm: no code map at %s", *mapPath)
+		}
+		if m.SHA1 != romHash(program) {
+			return fmt.Errorf("disasm: code map %s is for rom %s", *mapPath, m.SHA1)
+		}
+		// the map knows where the rom was loaded
+		l.entry += m.Load - l.load
+		l.load = m.Load
+	}
+
+	c := &cpu{layout: l}
+	if err := c.init(program); err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	writeDisassembly(out, &c.mem, l, len(program), m)
+	return nil
+}
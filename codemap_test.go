@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCodeMap(t *testing.T) {
+	program := []byte{
+		0xA2, 0x0C, // 0x200: i = 0x20C
+		0xD0, 0x01, // 0x202: draw one row
+		0xA2, 0x00, // 0x204: i = 0x200
+		0x60, 0x12, // 0x206: v0 = 0x12
+		0xF0, 0x55, // 0x208: mem[i] = v0, over the first instruction
+		0x12, 0x0A, // 0x20A: loop in place
+		0xF0, // 0x20C: sprite
+		0x00, // 0x20D: padding, never touched
+	}
+	c := &cpu{}
+	if err := c.init(program); err != nil {
+		t.Fatal(err)
+	}
+	c.prof = newProfile(0x200)
+	var flagged [][2]uint16
+	c.prof.selfModPlugin = func(pc, addr uint16) { flagged = append(flagged, [2]uint16{pc, addr}) }
+	for k := 0; k < 6; k++ {
+		if err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+		if k < 4 && len(flagged) != 0 {
+			t.Fatalf("fatal codemap error: expected no write flagged before the store, got %v", flagged)
+		}
+	}
+	if len(flagged) != 1 || flagged[0] != [2]uint16{0x208, 0x200} {
+		t.Fatalf("fatal codemap error: expected the store flagged as it ran, got %v", flagged)
+	}
+
+	mods := c.prof.selfModified()
+	if len(mods) != 1 || mods[0] != (selfMod{0x208, 0x200, 1}) {
+		t.Fatalf("fatal codemap error: expected a write by 0x208 into 0x200, got %+v", mods)
+	}
+	m := c.prof.codeMap(program, 0x200)
+	if m.Access != "51111111111120" {
+		t.Fatalf("fatal codemap error: expected access 51111111111120, got %s", m.Access)
+	}
+
+	// saving again folds the runs together
+	path := filepath.Join(t.TempDir(), "map.json")
+	for k := 0; k < 2; k++ {
+		if err := saveCodeMap(path, c.prof.codeMap(program, 0x200)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved, err := loadCodeMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Access != m.Access || len(saved.SelfMod) != 1 || saved.SelfMod[0].Count != 2 {
+		t.Fatalf("fatal codemap error: expected the saved runs merged, got %+v", saved)
+	}
+	other := &codeMap{SHA1: romHash([]byte{0}), Load: 0x200, Access: "0"}
+	if err := saveCodeMap(path, other); err == nil {
+		t.Fatalf("fatal codemap error: expected a map of another rom refused")
+	}
+
+	fresh := &cpu{}
+	fresh.init(program)
+	out := &bytes.Buffer{}
+	writeDisassembly(out, &fresh.mem, standardLayout, len(program), saved)
+	for _, line := range []string{
+		"0x200  A20C  ANNN  i = nnn  ; modified by 0x208",
+		"0x20A  120A  1NNN  jump",
+		"0x20C  F0    data  ####....",
+		"0x20D  00    data  ........",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("fatal codemap error: expected %q in\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// how well a rom ran, higher is better
+func compatScore(r batchResult) int {
+	switch {
+	case r.Trap != "" || r.Error != "":
+		return 0
+	case r.Blank && r.Stuck:
+		return 1
+	case r.Blank:
+		return 2
+	case r.Stuck:
+		// test roms and finished games end this way too
+		return 3
+	}
+	return 4
+}
+
+// one word for how a run ended
+func compatStatus(r batchResult) string {
+	switch {
+	case r.Trap != "":
+		return strings.TrimPrefix(r.Trap, "fatal error: ")
+	case r.Error != "":
+		return r.Error
+	case r.Blank && r.Stuck:
+		return "stuck blank"
+	case r.Blank:
+		return "blank"
+	case r.Stuck:
+		return fmt.Sprintf("stuck at frame %d", r.Frames)
+	}
+	return "ok"
+}
+
+// a rom's results under every preset
+type compatRow struct {
+	rom     string
+	results map[string]batchResult // by quirk preset
+	best    int                    // highest score
+	suggest string                 // preset to use
+}
+
+// order presets are suggested in when they score the same
+func compatPresets() []string {
+	presets := []string{"chip8"}
+	for _, name := range quirkPresetNames() {
+		if name != "chip8" {
+			presets = append(presets, name)
+		}
+	}
+	return presets
+}
+
+// run every rom under every preset, worst roms first
+func compatRun(roms []string, programs [][]byte, frames, ipf, workers int) []compatRow {
+	presets := compatPresets()
+	rows := make([]compatRow, len(roms))
+	for k, rom := range roms {
+		rows[k] = compatRow{rom: rom, results: map[string]batchResult{}, best: -1}
+	}
+	for _, preset := range presets {
+		jobs := make([]batchJob, len(roms))
+		for k := range roms {
+			jobs[k] = batchJob{rom: roms[k], program: programs[k], seed: 1}
+		}
+		cfg := batchConfig{frames: frames, ipf: ipf, quirks: quirkPresets[preset]}
+		for k, res := range cfg.runAll(jobs, workers) {
+			rows[k].results[preset] = res
+			if score := compatScore(res); score > rows[k].best {
+				rows[k].best, rows[k].suggest = score, preset
+			}
+		}
+	}
+	sort.SliceStable(rows, func(a, b int) bool {
+		return rows[a].best < rows[b].best
+	})
+	return rows
+}
+
+func writeCompatTable(w io.Writer, rows []compatRow) {
+	presets := compatPresets()
+	fmt.Fprintf(w, "%-24s %-8s", "rom", "suggest")
+	for _, p := range presets {
+		fmt.Fprintf(w, " %-24s", p)
+	}
+	fmt.Fprintln(w)
+	for _, row := range rows {
+		suggest := row.suggest
+		if row.best == 0 {
+			suggest = "-"
+		}
+		fmt.Fprintf(w, "%-24s %-8s", filepath.Base(row.rom), suggest)
+		for _, p := range presets {
+			fmt.Fprintf(w, " %-24s", compatStatus(row.results[p]))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// every rom under dir as browse finds them, archives and cartridges
+// included, reporting and leaving out any that will not load
+func compatROMs(dir string) ([]string, [][]byte, error) {
+	paths, err := scanLibrary(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var roms []string
+	var programs [][]byte
+	for _, path := range paths {
+		r, err := loadROM(path, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "compat: skipping %s\n", err)
+			continue
+		}
+		roms = append(roms, path)
+		programs = append(programs, r.data)
+	}
+	return roms, programs, nil
+}
+
+// chip8 compat [-seconds n] [-ipf n] [-workers n] dir
+func compatCmd(args []string) error {
+	fs := flag.NewFlagSet("compat", flag.ContinueOnError)
+	seconds := fs.Float64("seconds", 10, "emulated seconds to run each rom for")
+	ipf := fs.Int("ipf", defaultIPF, "instructions per 60Hz frame")
+	workers := fs.Int("workers", runtime.NumCPU(), "machines run at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("compat: expected one directory, got %d", fs.NArg())
+	}
+	frames := int(*seconds * 60)
+	if frames < 1 || *ipf < 1 || *workers < 1 {
+		return fmt.Errorf("compat: seconds, ipf and workers must be positive")
+	}
+
+	roms, programs, err := compatROMs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(roms) == 0 {
+		return fmt.Errorf("compat: no roms in %s", fs.Arg(0))
+	}
+
+	// keep instruction traces out of the run
+	log.SetOutput(ioutil.Discard)
+
+	writeCompatTable(os.Stdout, compatRun(roms, programs, frames, *ipf, *workers))
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompat(t *testing.T) {
+	pong, err := ioutil.ReadFile("pong.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roms := []string{"pong.ch8", "shifty.ch8", "broken.ch8"}
+	programs := [][]byte{
+		pong,
+		{
+			0x60, 0x00, // v0 = 0
+			0x61, 0x02, // v1 = 2
+			0x80, 0x16, // v0 = v1 >> 1 with the shift quirk, else v0 >> 1
+			0x30, 0x01, // if v0 == 1: skip
+			0x00, 0x00, // trap
+			0xF0, 0x29, // i = &SPRITE(v0)
+			0xD0, 0x05, // draw
+			0x12, 0x0E, // loop in place
+		},
+		{0x00, 0x01},
+	}
+	rows := compatRun(roms, programs, 60, defaultIPF, 2)
+
+	if rows[0].rom != "broken.ch8" || rows[0].best != 0 {
+		t.Fatalf("fatal compat error: expected broken.ch8 ranked first, got %s", rows[0].rom)
+	}
+	if !strings.HasPrefix(compatStatus(rows[0].results["chip8"]), "unknown opcode") {
+		t.Fatalf("fatal compat error for broken.ch8: expected an unknown opcode, got %q", compatStatus(rows[0].results["chip8"]))
+	}
+	if rows[1].rom != "shifty.ch8" || !quirkPresets[rows[1].suggest].shift {
+		t.Fatalf("fatal compat error for shifty.ch8: expected a preset with the shift quirk, got %s %q", rows[1].rom, rows[1].suggest)
+	}
+	if status := compatStatus(rows[1].results[rows[1].suggest]); !strings.HasPrefix(status, "stuck") {
+		t.Fatalf("fatal compat error for shifty.ch8: expected it to end looping in place, got %q", status)
+	}
+	if rows[2].rom != "pong.ch8" || rows[2].suggest != "chip8" || rows[2].best != 4 {
+		t.Fatalf("fatal compat error for pong.ch8: expected chip8 suggested, got %s %q", rows[2].rom, rows[2].suggest)
+	}
+
+	out := &bytes.Buffer{}
+	writeCompatTable(out, rows)
+	if lines := strings.Count(out.String(), "\n"); lines != 4 {
+		t.Fatalf("fatal compat error: expected a header and three rows, got %d lines", lines)
+	}
+}
+
+func TestCompatROMs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zipped := &bytes.Buffer{}
+	z := zip.NewWriter(zipped)
+	w, err := z.Create("b.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte{0x12, 0x00})
+	z.Close()
+	gzipped := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzipped)
+	gz.Write([]byte{0x12, 0x02})
+	gz.Close()
+	cart, err := newCartridge([]byte{0x00, 0xE0, 0x12, 0x02}, "c", romOptions{palette: "green"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gif := &bytes.Buffer{}
+	if err := encodeCartridge(gif, cart); err != nil {
+		t.Fatal(err)
+	}
+
+	write("a.zip", zipped.Bytes())
+	write("b.ch8.gz", gzipped.Bytes())
+	write("c.gif", gif.Bytes())
+	write("d.gif", []byte("GIF89a not a cartridge"))
+	write("notes.txt", []byte("not a rom"))
+
+	// the cartridge runs as its program, the broken one is left out
+	roms, programs, err := compatROMs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a.zip") + "#b.ch8", filepath.Join(dir, "b.ch8.gz"), filepath.Join(dir, "c.gif")}
+	if !reflect.DeepEqual(roms, want) {
+		t.Fatalf("fatal compat error: expected %v, got %v", want, roms)
+	}
+	if !bytes.Equal(programs[2], []byte{0x00, 0xE0, 0x12, 0x02}) {
+		t.Fatalf("fatal compat error for c.gif: expected the assembled program, got % X", programs[2])
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// v0 counts instructions, v1 stays zero
+var counter = []byte{
+	0x70, 0x01, // v0 += 1
+	0x12, 0x00, // loop
+}
+
+func TestFrameAdvance(t *testing.T) {
+	c := &cpu{paused: true}
+	c.init(counter)
+
+	if err := c.frame(2); err != nil {
+		t.Fatal(err)
+	}
+	if c.v[0] != 0 {
+		t.Fatalf("fatal pause error: expected nothing to run, got v0 %d", c.v[0])
+	}
+
+	c.advance = true
+	for k := 0; k < 3; k++ {
+		if err := c.frame(2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.v[0] != 1 || !c.paused {
+		t.Fatalf("fatal frame advance error: expected one frame then paused, got v0 %d (paused %t)", c.v[0], c.paused)
+	}
+}
+
+func TestNotice(t *testing.T) {
+	c := &cpu{}
+	c.init([]byte{0x12, 0x00})
+	c.announce("SELF-MOD 0x208 -> 0x200")
+	for k := 0; k < noticeFrames; k++ {
+		if c.status() != "SELF-MOD 0x208 -> 0x200" {
+			t.Fatalf("fatal notice error for frame %d: expected the notice, got %q", k, c.status())
+		}
+		if err := c.frame(2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.status() != "" {
+		t.Fatalf("fatal notice error: expected the notice gone, got %q", c.status())
+	}
+}
+
+func TestSpeed(t *testing.T) {
+	tests := []struct {
+		speed  float64
+		frames int
+		sleep  time.Duration
+		status string
+	}{
+		{0, 1, time.Second / 60, ""},
+		{1, 1, time.Second / 60, ""},
+		{4, 4, time.Second / 60, "FAST x4"},
+		{0.25, 1, 4 * time.Second / 60, "SLOW x1/4"},
+	}
+
+	for _, test := range tests {
+		c := &cpu{speed: test.speed}
+		c.init(counter)
+		kill := false
+		var slept time.Duration
+		c.cycle(2, func() {}, func(d time.Duration) {
+			slept = d
+			kill = true
+		}, &kill)
+
+		// every frame runs two instructions, one of them the add
+		if int(c.v[0]) != test.frames {
+			t.Fatalf("fatal speed error for x%g: expected %d frames, got %d", test.speed, test.frames, c.v[0])
+		}
+		if slept > test.sleep || slept < test.sleep-10*time.Millisecond {
+			t.Fatalf("fatal speed error for x%g: expected to sleep about %s, got %s", test.speed, test.sleep, slept)
+		}
+		if c.status() != test.status {
+			t.Fatalf("fatal speed error for x%g: expected status %q, got %q", test.speed, test.status, c.status())
+		}
+	}
+}
+
+func TestTermStatus(t *testing.T) {
+	cells := map[[2]int]rune{}
+	v := &termVideo{
+		mode: termHalf,
+		setCellPlugin: func(x, y int, c rune, fg, bg termbox.Attribute) {
+			cells[[2]int{x, y}] = c
+		},
+		flushPlugin: func() error { return nil },
+	}
+	disp := [32][64]uint8{}
+
+	v.status("PAUSED")
+	v.present(&disp, allRows)
+	if cells[[2]int{0, 16}] != 'P' || cells[[2]int{5, 16}] != 'D' {
+		t.Fatalf("fatal status error: expected PAUSED under the display")
+	}
+
+	v.status("")
+	v.present(&disp, 0)
+	if cells[[2]int{0, 16}] != ' ' || cells[[2]int{5, 16}] != ' ' {
+		t.Fatalf("fatal status error: expected the status line blanked")
+	}
+}
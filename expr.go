@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression over machine state, such as a score or a game over test
+//
+//	mem[0x2F0]          byte in memory
+//	bcd(0x2F0)          three digit BCD number as written by FX33
+//	v[3], i, dt, st     registers
+//	+ - * / %           arithmetic
+//	== != < <= > >=     comparisons, 1 when true and 0 otherwise
+//	&& || !             logic on zero and non-zero
+type expr func(c *cpu) int
+
+func parseExpr(s string) (expr, error) {
+	p := &exprParser{tokens: lexExpr(s)}
+	e, err := p.expr()
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %s", s, err)
+	}
+	if p.peek() != "" {
+		return nil, fmt.Errorf("expression %q: unexpected %q", s, p.peek())
+	}
+	return e, nil
+}
+
+// split into numbers, names and operators
+func lexExpr(s string) []string {
+	var tokens []string
+	for k := 0; k < len(s); {
+		r := rune(s[k])
+		switch {
+		case unicode.IsSpace(r):
+			k++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := k
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, s[k:j])
+			k = j
+		default:
+			n := 1
+			if k+1 < len(s) {
+				switch s[k : k+2] {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					n = 2
+				}
+			}
+			tokens = append(tokens, s[k:k+n])
+			k += n
+		}
+	}
+	return tokens
+}
+
+type exprParser struct {
+	tokens []string
+	next   int
+}
+
+func (p *exprParser) peek() string {
+	if p.next < len(p.tokens) {
+		return p.tokens[p.next]
+	}
+	return ""
+}
+
+func (p *exprParser) expect(tok string) error {
+	if p.peek() != tok {
+		return fmt.Errorf("expected %q, got %q", tok, p.peek())
+	}
+	p.next++
+	return nil
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// binary operators by precedence, loosest first
+var exprLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// a whole expression, or one in parentheses or brackets
+func (p *exprParser) expr() (expr, error) {
+	return p.binary(0)
+}
+
+func (p *exprParser) binary(level int) (expr, error) {
+	if level == len(exprLevels) {
+		return p.unary()
+	}
+	lhs, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, o := range exprLevels[level] {
+			found = found || o == op
+		}
+		if !found {
+			return lhs, nil
+		}
+		p.next++
+		rhs, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		lhs = combine(op, lhs, rhs)
+	}
+}
+
+func combine(op string, a, b expr) expr {
+	switch op {
+	case "||":
+		return func(c *cpu) int { return truth(a(c) != 0 || b(c) != 0) }
+	case "&&":
+		return func(c *cpu) int { return truth(a(c) != 0 && b(c) != 0) }
+	case "==":
+		return func(c *cpu) int { return truth(a(c) == b(c)) }
+	case "!=":
+		return func(c *cpu) int { return truth(a(c) != b(c)) }
+	case "<":
+		return func(c *cpu) int { return truth(a(c) < b(c)) }
+	case "<=":
+		return func(c *cpu) int { return truth(a(c) <= b(c)) }
+	case ">":
+		return func(c *cpu) int { return truth(a(c) > b(c)) }
+	case ">=":
+		return func(c *cpu) int { return truth(a(c) >= b(c)) }
+	case "+":
+		return func(c *cpu) int { return a(c) + b(c) }
+	case "-":
+		return func(c *cpu) int { return a(c) - b(c) }
+	case "*":
+		return func(c *cpu) int { return a(c) * b(c) }
+	case "/":
+		return func(c *cpu) int {
+			if d := b(c); d != 0 {
+				return a(c) / d
+			}
+			return 0
+		}
+	}
+
+	// the only operator left is %
+	return func(c *cpu) int {
+		if d := b(c); d != 0 {
+			return a(c) % d
+		}
+		return 0
+	}
+}
+
+func (p *exprParser) unary() (expr, error) {
+	switch p.peek() {
+	case "-":
+		p.next++
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(c *cpu) int { return -e(c) }, nil
+	case "!":
+		p.next++
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(c *cpu) int { return truth(e(c) == 0) }, nil
+	}
+	return p.operand()
+}
+
+// an index in brackets or an address in parentheses
+func (p *exprParser) argument(open, close string) (expr, error) {
+	if err := p.expect(open); err != nil {
+		return nil, err
+	}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return e, p.expect(close)
+}
+
+func (p *exprParser) operand() (expr, error) {
+	tok := p.peek()
+	p.next++
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end")
+	case "(":
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case "mem":
+		addr, err := p.argument("[", "]")
+		if err != nil {
+			return nil, err
+		}
+		return func(c *cpu) int { return int(c.mem[addr(c)&0xFFF]) }, nil
+	case "bcd":
+		addr, err := p.argument("(", ")")
+		if err != nil {
+			return nil, err
+		}
+		return func(c *cpu) int {
+			a := addr(c)
+			return 100*int(c.mem[a&0xFFF]) + 10*int(c.mem[(a+1)&0xFFF]) + int(c.mem[(a+2)&0xFFF])
+		}, nil
+	case "v":
+		x, err := p.argument("[", "]")
+		if err != nil {
+			return nil, err
+		}
+		return func(c *cpu) int { return int(c.v[x(c)&0xF]) }, nil
+	case "i":
+		return func(c *cpu) int { return int(c.i) }, nil
+	case "dt":
+		return func(c *cpu) int { return int(c.dt) }, nil
+	case "st":
+		return func(c *cpu) int { return int(c.st) }, nil
+	}
+
+	n, err := strconv.ParseInt(strings.ToLower(tok), 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+	return func(c *cpu) int { return int(n) }, nil
+}
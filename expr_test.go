@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	c := &cpu{}
+	c.mem[0x300], c.mem[0x301], c.mem[0x302] = 1, 2, 7
+	c.v[3] = 9
+	c.i = 0x300
+
+	tests := []struct {
+		expr     string
+		expected int
+	}{
+		{"42", 42},
+		{"0x2A", 42},
+		{"mem[0x301]", 2},
+		{"mem[i + 2]", 7},
+		{"bcd(0x300)", 127},
+		{"v[3] * 2 + 1", 19},
+		{"(v[3] + 1) * 2", 20},
+		{"-v[3] % 4", -1},
+		{"v[3] == 9 && mem[0x300] != 0", 1},
+		{"v[3] < 9 || !mem[0x303]", 1},
+		{"bcd(0x300) >= 128", 0},
+		{"7 / 0", 0},
+	}
+
+	for _, test := range tests {
+		e, err := parseExpr(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e(c); got != test.expected {
+			t.Fatalf("fatal expression error for %s: expected %d, got %d", test.expr, test.expected, got)
+		}
+	}
+
+	for _, bad := range []string{"", "mem[1", "v 3", "1 +", "score", "(1))"} {
+		if _, err := parseExpr(bad); err == nil {
+			t.Fatalf("fatal expression error for %q: expected a parse error", bad)
+		}
+	}
+}
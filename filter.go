@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+)
+
+// display post-process between the cpu and a video backend,
+// turning lit pixels into brightness from 0x00 to 0xFF
+type filter interface {
+	apply(disp, out *[32][64]uint8)
+}
+
+// show the display as is
+type noFilter struct{}
+
+func (noFilter) apply(disp, out *[32][64]uint8) {
+	for y := range disp {
+		for x := range disp[y] {
+			out[y][x] = 0x00
+			if disp[y][x] != 0 {
+				out[y][x] = 0xFF
+			}
+		}
+	}
+}
+
+// OR together the last n frames, so a sprite erased and redrawn on
+// consecutive frames never disappears
+type blendFilter struct {
+	history [][32][64]uint8
+	next    int // history slot for the next frame
+}
+
+func newBlendFilter(n int) *blendFilter {
+	return &blendFilter{history: make([][32][64]uint8, n)}
+}
+
+func (f *blendFilter) apply(disp, out *[32][64]uint8) {
+	f.history[f.next] = *disp
+	f.next = (f.next + 1) % len(f.history)
+	for y := range out {
+		for x := range out[y] {
+			out[y][x] = 0x00
+			for k := range f.history {
+				if f.history[k][y][x] != 0 {
+					out[y][x] = 0xFF
+					break
+				}
+			}
+		}
+	}
+}
+
+// phosphor persistence, unlit pixels fade out exponentially
+type decayFilter struct {
+	factor float64         // brightness kept per frame, 0 to 1
+	level  [32][64]float64 // current brightness, 0 to 1
+}
+
+// faded pixels below this brightness go dark
+const decayCutoff = 1.0 / 8
+
+func (f *decayFilter) apply(disp, out *[32][64]uint8) {
+	for y := range disp {
+		for x := range disp[y] {
+			if disp[y][x] != 0 {
+				f.level[y][x] = 1
+			} else {
+				f.level[y][x] *= f.factor
+				if f.level[y][x] < decayCutoff {
+					f.level[y][x] = 0
+				}
+			}
+			out[y][x] = uint8(f.level[y][x] * 0xFF)
+		}
+	}
+}
+
+// build a filter by name, blend takes n frames and decay keeps factor per frame
+func newFilter(name string, n int, factor float64) (filter, error) {
+	switch name {
+	case "none":
+		return noFilter{}, nil
+	case "blend":
+		if n < 1 {
+			return nil, fmt.Errorf("blend needs at least one frame, got %d", n)
+		}
+		return newBlendFilter(n), nil
+	case "decay":
+		if factor < 0 || factor >= 1 {
+			return nil, fmt.Errorf("decay factor must be in [0, 1), got %g", factor)
+		}
+		return &decayFilter{factor: factor}, nil
+	}
+	return nil, fmt.Errorf("unknown filter %q", name)
+}
+
+// video backend fed through a filter
+type filteredVideo struct {
+	filter filter
+	next   video
+	out    [32][64]uint8 // filtered display
+	last   [32][64]uint8 // filtered display at the previous present
+	shown  bool          // has anything been presented yet?
+}
+
+func newFilteredVideo(f filter, next video) *filteredVideo {
+	return &filteredVideo{filter: f, next: next}
+}
+
+// filtered pixels change without the cpu drawing, so the rows to
+// redraw come from comparing against the last presented frame
+func (v *filteredVideo) present(disp *[32][64]uint8, dirty uint32) error {
+	v.filter.apply(disp, &v.out)
+	dirty = 0
+	for y := range v.out {
+		if !v.shown || v.out[y] != v.last[y] {
+			dirty |= 1 << y
+		}
+	}
+	v.last = v.out
+	v.shown = true
+	return v.next.present(&v.out, dirty)
+}
+
+func (v *filteredVideo) status(text string) error {
+	if sv, ok := v.next.(statusVideo); ok {
+		return sv.status(text)
+	}
+	return nil
+}
+
+func (v *filteredVideo) close() error {
+	return v.next.close()
+}
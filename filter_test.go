@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+)
+
+// present a sequence of frames with pixel (0,0) lit or not and
+// collect its filtered brightness
+func mockFilterFrames(f filter, lit []bool) []uint8 {
+	var disp, out [32][64]uint8
+	levels := []uint8{}
+	for _, on := range lit {
+		disp[0][0] = 0
+		if on {
+			disp[0][0] = 1
+		}
+		f.apply(&disp, &out)
+		levels = append(levels, out[0][0])
+	}
+	return levels
+}
+
+func TestFilters(t *testing.T) {
+	cases := []struct {
+		desc     string
+		filter   filter
+		lit      []bool
+		expected []uint8
+	}{
+		{"none", noFilter{}, []bool{true, false, true}, []uint8{0xFF, 0x00, 0xFF}},
+		{"blend", newBlendFilter(2), []bool{true, false, false, true}, []uint8{0xFF, 0xFF, 0x00, 0xFF}},
+		{"decay", &decayFilter{factor: 0.5}, []bool{true, false, false, false, false}, []uint8{0xFF, 0x7F, 0x3F, 0x1F, 0x00}},
+	}
+	for _, tc := range cases {
+		got := mockFilterFrames(tc.filter, tc.lit)
+		for k := range tc.expected {
+			if got[k] != tc.expected[k] {
+				t.Fatalf("fatal filter error for %s: expected 0x%X, got 0x%X at frame %d", tc.desc, tc.expected[k], got[k], k)
+			}
+		}
+	}
+}
+
+// remember what a backend was asked to show
+type mockVideo struct {
+	disp  [32][64]uint8
+	dirty uint32
+}
+
+func (v *mockVideo) present(disp *[32][64]uint8, dirty uint32) error {
+	v.disp, v.dirty = *disp, dirty
+	return nil
+}
+
+func (v *mockVideo) close() error { return nil }
+
+func TestFilteredVideoDirtyRows(t *testing.T) {
+	next := &mockVideo{}
+	v := newFilteredVideo(&decayFilter{factor: 0.5}, next)
+	disp := [32][64]uint8{}
+	disp[3][0] = 1
+
+	v.present(&disp, allRows)
+	if next.dirty != allRows {
+		t.Fatalf("fatal dirty row error: expected every row on the first frame, got 0x%X", next.dirty)
+	}
+
+	// the fading pixel keeps its row dirty although the cpu drew nothing
+	disp[3][0] = 0
+	v.present(&disp, 0)
+	if next.dirty != 1<<3 || next.disp[3][0] != 0x7F {
+		t.Fatalf("fatal decay error: expected row 3 dirty at 0x7F, got rows 0x%X at 0x%X", next.dirty, next.disp[3][0])
+	}
+}
+
+func TestDisplayWait(t *testing.T) {
+	c := &cpu{quirks: quirks{wait: true}}
+	c.init([]byte{
+		0xD0, 0x01, // draw
+		0x60, 0x01, // v0 = 1
+		0x12, 0x00, // loop
+	})
+	n, err := c.run(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || c.v[0] != 0 {
+		t.Fatalf("fatal display wait error: expected the frame to end after DXYN, ran %d instructions", n)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+)
+
+// kinds of control flow edge
+const (
+	edgeNext     = "next"     // falls through into the next block
+	edgeJump     = "jump"     // 1NNN
+	edgeCall     = "call"     // 2NNN to the routine
+	edgeReturn   = "return"   // 2NNN on to the instruction after it
+	edgeSkip     = "skip"     // skip instruction taken
+	edgeNoSkip   = "noskip"   // skip instruction not taken
+	edgeIndirect = "indirect" // BNNN target seen in a trace
+)
+
+type flowInstr struct {
+	Addr   uint16 `json:"addr"`
+	Opcode uint16 `json:"opcode"`
+	Name   string `json:"name"`
+}
+
+// straight-line run of instructions entered only at the top
+type flowBlock struct {
+	Start    uint16      `json:"start"`
+	End      uint16      `json:"end"` // address after the last instruction
+	Instrs   []flowInstr `json:"instructions"`
+	Routine  uint16      `json:"routine"`  // entry of the routine it belongs to
+	Indirect bool        `json:"indirect"` // ends in a BNNN
+	Invalid  bool        `json:"invalid"`  // ends in an unknown opcode or runs off the program
+}
+
+type flowEdge struct {
+	From uint16 `json:"from"` // block start
+	To   uint16 `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// control flow and call graphs of a program
+type flowGraph struct {
+	Entry    uint16       `json:"entry"`
+	Blocks   []*flowBlock `json:"blocks"`
+	Edges    []flowEdge   `json:"edges"`
+	Calls    []flowEdge   `json:"calls"`    // routine to routine
+	Outside  []flowEdge   `json:"outside"`  // jumps and calls out of the program
+	Routines []uint16     `json:"routines"` // entry point first, then by address
+}
+
+// recover the graphs from memory as cpu.init leaves it, starting at the
+// entry point and any extra roots, with BNNN targets seen in traces
+func buildFlowGraph(mem *[4096]uint8, l layout, size int, roots []uint16, indirect map[uint16][]uint16) *flowGraph {
+	lo, hi := int(l.load), int(l.load)+size
+	inside := func(a uint16) bool { return int(a) >= lo && int(a)+1 < hi }
+	opcodeAt := func(a uint16) uint16 { return uint16(mem[a])<<8 | uint16(mem[a+1]) }
+
+	g := &flowGraph{Entry: l.entry}
+
+	// successors of the instruction at a, and whether it ends a block
+	type successor struct {
+		to   uint16
+		kind string
+	}
+	successors := func(a uint16) ([]successor, bool) {
+		opcode := opcodeAt(a)
+		in := dispatch[opcode]
+		next := a + 2
+		switch in {
+		case op1NNN:
+			return []successor{{opNNN(opcode), edgeJump}}, true
+		case op2NNN:
+			return []successor{{opNNN(opcode), edgeCall}, {next, edgeReturn}}, true
+		case op3XKK, op4XKK, op5XY0, op9XY0, opEX9E, opEXA1:
+			return []successor{{next, edgeNoSkip}, {next + 2, edgeSkip}}, true
+		case opBNNN:
+			var out []successor
+			for _, t := range indirect[a] {
+				out = append(out, successor{t, edgeIndirect})
+			}
+			return out, true
+		case op00EE, unknown:
+			return nil, true
+		}
+		return []successor{{next, edgeNext}}, false
+	}
+
+	// find block leaders by following every path
+	leaders := map[uint16]bool{}
+	routines := map[uint16]bool{l.entry: true}
+	code := map[uint16]bool{}
+	work := append([]uint16{l.entry}, roots...)
+	for _, r := range roots {
+		routines[r] = true
+	}
+	for len(work) > 0 {
+		a := work[len(work)-1]
+		work = work[:len(work)-1]
+		if !inside(a) || leaders[a] {
+			continue
+		}
+		leaders[a] = true
+		for start := a; inside(a); a += 2 {
+			// the rest of the way is known already
+			if code[a] && a != start {
+				break
+			}
+			code[a] = true
+			succ, ends := successors(a)
+			if !ends {
+				continue
+			}
+			for _, s := range succ {
+				if s.kind == edgeCall {
+					routines[s.to] = true
+				}
+				if inside(s.to) {
+					work = append(work, s.to)
+				}
+			}
+			break
+		}
+	}
+
+	// cut blocks at leaders and terminators
+	starts := make([]uint16, 0, len(leaders))
+	for a := range leaders {
+		starts = append(starts, a)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, start := range starts {
+		b := &flowBlock{Start: start}
+		a := start
+		for {
+			if !inside(a) {
+				b.Invalid = true
+				break
+			}
+			opcode := opcodeAt(a)
+			in := dispatch[opcode]
+			b.Instrs = append(b.Instrs, flowInstr{a, opcode, in.name})
+			succ, ends := successors(a)
+			a += 2
+			if ends {
+				b.Indirect = in == opBNNN
+				b.Invalid = in == unknown
+				for _, s := range succ {
+					e := flowEdge{start, s.to, s.kind}
+					if inside(s.to) {
+						g.Edges = append(g.Edges, e)
+					} else {
+						g.Outside = append(g.Outside, e)
+					}
+				}
+				break
+			}
+			if leaders[a] {
+				g.Edges = append(g.Edges, flowEdge{start, a, edgeNext})
+				break
+			}
+		}
+		b.End = a
+		g.Blocks = append(g.Blocks, b)
+	}
+
+	// a routine is everything reachable from its entry without calling
+	for r := range routines {
+		if inside(r) {
+			g.Routines = append(g.Routines, r)
+		}
+	}
+	sort.Slice(g.Routines, func(i, j int) bool {
+		ri, rj := g.Routines[i], g.Routines[j]
+		if (ri == l.entry) != (rj == l.entry) {
+			return ri == l.entry
+		}
+		return ri < rj
+	})
+	owner := map[uint16]uint16{}
+	calls := map[flowEdge]bool{}
+	for _, r := range g.Routines {
+		seen := map[uint16]bool{r: true}
+		queue := []uint16{r}
+		for len(queue) > 0 {
+			a := queue[0]
+			queue = queue[1:]
+			if _, ok := owner[a]; !ok {
+				owner[a] = r
+			}
+			for _, e := range g.Edges {
+				if e.From != a {
+					continue
+				}
+				if e.Kind == edgeCall {
+					calls[flowEdge{r, e.To, edgeCall}] = true
+					continue
+				}
+				if !seen[e.To] {
+					seen[e.To] = true
+					queue = append(queue, e.To)
+				}
+			}
+		}
+	}
+	for _, b := range g.Blocks {
+		b.Routine = owner[b.Start]
+	}
+	for e := range calls {
+		g.Calls = append(g.Calls, e)
+	}
+	sort.Slice(g.Calls, func(i, j int) bool {
+		if g.Calls[i].From != g.Calls[j].From {
+			return g.Calls[i].From < g.Calls[j].From
+		}
+		return g.Calls[i].To < g.Calls[j].To
+	})
+	return g
+}
+
+// BNNN targets seen running the machine through the interpreter
+// (a fault ends the trace early, keeping what was seen)
+func traceIndirect(c *cpu, steps int) map[uint16][]uint16 {
+	seen := map[[2]uint16]bool{}
+	targets := map[uint16][]uint16{}
+	for k := 0; k < steps; k++ {
+		a := c.pc
+		if int(a)+1 >= len(c.mem) {
+			break
+		}
+		opcode := uint16(c.mem[a])<<8 | uint16(c.mem[a+1])
+		if _, err := c.run(1); err != nil {
+			break
+		}
+		if dispatch[opcode] == opBNNN && !seen[[2]uint16{a, c.pc}] {
+			seen[[2]uint16{a, c.pc}] = true
+			targets[a] = append(targets[a], c.pc)
+		}
+	}
+	return targets
+}
+
+// dot styles by edge kind
+var flowEdgeStyles = map[string]string{
+	edgeNext:     "",
+	edgeJump:     "",
+	edgeCall:     " style=dashed color=blue",
+	edgeReturn:   " style=dotted",
+	edgeSkip:     ` label="skip"`,
+	edgeNoSkip:   "",
+	edgeIndirect: ` color=red label="indirect"`,
+}
+
+// control flow graph as graphviz dot, one box per block,
+// unresolved BNNN blocks in red
+func (g *flowGraph) writeDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph cfg {")
+	fmt.Fprintln(w, "\tnode [shape=box fontname=monospace];")
+	for _, b := range g.Blocks {
+		var label strings.Builder
+		for _, in := range b.Instrs {
+			fmt.Fprintf(&label, "0x%03X  %04X  %s\\l", in.Addr, in.Opcode, in.Name)
+		}
+		style := ""
+		switch {
+		case b.Indirect && !g.resolved(b.Start):
+			style = " color=red"
+		case b.Invalid:
+			style = " color=grey"
+		}
+		fmt.Fprintf(w, "\tb%03X [label=\"%s\"%s];\n", b.Start, label.String(), style)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "\tb%03X -> b%03X [%s];\n", e.From, e.To, strings.TrimSpace(flowEdgeStyles[e.Kind]))
+	}
+	for _, e := range g.Outside {
+		fmt.Fprintf(w, "\tx%03X [label=\"0x%03X\" shape=plaintext];\n", e.To, e.To)
+		fmt.Fprintf(w, "\tb%03X -> x%03X [%s];\n", e.From, e.To, strings.TrimSpace(flowEdgeStyles[e.Kind]))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// call graph as graphviz dot, one node per routine
+func (g *flowGraph) writeCallDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph calls {")
+	fmt.Fprintln(w, "\tnode [shape=ellipse fontname=monospace];")
+	for _, r := range g.Routines {
+		label := fmt.Sprintf("0x%03X", r)
+		if r == g.Entry {
+			label += " main"
+		}
+		fmt.Fprintf(w, "\tr%03X [label=\"%s\"];\n", r, label)
+	}
+	for _, e := range g.Calls {
+		fmt.Fprintf(w, "\tr%03X -> r%03X;\n", e.From, e.To)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// has a trace found where the BNNN ending this block goes?
+func (g *flowGraph) resolved(block uint16) bool {
+	for _, e := range g.Edges {
+		if e.From == block && e.Kind == edgeIndirect {
+			return true
+		}
+	}
+	for _, e := range g.Outside {
+		if e.From == block && e.Kind == edgeIndirect {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *flowGraph) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// chip8 graph [-format dot|json] [-calls] [-trace frames] [-o file] rom
+func graphCmd(args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := fs.String("format", "dot", "output format: dot or json")
+	calls := fs.Bool("calls", false, "dot output shows the call graph instead of the blocks")
+	trace := fs.Int("trace", 0, "resolve BNNN jumps by running this many frames first")
+	ipf := fs.Int("ipf", defaultIPF, "instructions per 60Hz frame while tracing")
+	quirksName := fs.String("quirks", "chip8", "platform quirks: "+strings.Join(quirkPresetNames(), ", "))
+	layoutName := fs.String("layout", "chip8", "memory layout: "+strings.Join(layoutNames(), ", "))
+	outPath := fs.String("o", "", "output file, stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("graph: expected one rom, got %d", fs.NArg())
+	}
+	r, err := loadROM(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	program := r.data
+	q, err := parseQuirks(*quirksName)
+	if err != nil {
+		return err
+	}
+	l, err := parseLayout(*layoutName)
+	if err != nil {
+		return err
+	}
+
+	// keep instruction traces out of the run
+	log.SetOutput(ioutil.Discard)
+
+	c := &cpu{
+		rng:           rand.New(rand.NewSource(1)),
+		quirks:        q,
+		layout:        l,
+		waitKeyPlugin: headlessWaitKey,
+	}
+	if err := c.init(program); err != nil {
+		return err
+	}
+	mem := c.mem
+	var indirect map[uint16][]uint16
+	if *trace > 0 {
+		indirect = traceIndirect(c, *trace**ipf)
+	}
+	g := buildFlowGraph(&mem, l, len(program), nil, indirect)
+
+	out := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	switch {
+	case *format == "json":
+		return g.writeJSON(out)
+	case *format != "dot":
+		return fmt.Errorf("graph: unknown format %q", *format)
+	case *calls:
+		g.writeCallDOT(out)
+	default:
+		g.writeDOT(out)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFlowGraph(t *testing.T) {
+	program := []byte{
+		0x22, 0x0A, // 0x200: call 0x20A
+		0x30, 0x00, // 0x202: if v0 == 0: skip
+		0x12, 0x00, // 0x204: jump 0x200
+		0x60, 0x02, // 0x206: v0 = 2
+		0xB2, 0x0C, // 0x208: jump v0 + 0x20C
+		0x70, 0x01, // 0x20A: v0 += 1
+		0x00, 0xEE, // 0x20C: return
+		0x12, 0x0E, // 0x20E: loop in place
+	}
+	c := &cpu{}
+	if err := c.init(program); err != nil {
+		t.Fatal(err)
+	}
+	mem := c.mem
+
+	// statically the BNNN goes nowhere
+	g := buildFlowGraph(&mem, standardLayout, len(program), nil, nil)
+	starts := []uint16{}
+	for _, b := range g.Blocks {
+		starts = append(starts, b.Start)
+	}
+	expected := []uint16{0x200, 0x202, 0x204, 0x206, 0x20A}
+	if len(starts) != len(expected) {
+		t.Fatalf("fatal flow error: expected blocks at %X, got %X", expected, starts)
+	}
+	for k := range expected {
+		if starts[k] != expected[k] {
+			t.Fatalf("fatal flow error: expected blocks at %X, got %X", expected, starts)
+		}
+	}
+	edges := map[flowEdge]bool{}
+	for _, e := range g.Edges {
+		edges[e] = true
+	}
+	for _, e := range []flowEdge{
+		{0x200, 0x20A, edgeCall},
+		{0x200, 0x202, edgeReturn},
+		{0x202, 0x204, edgeNoSkip},
+		{0x202, 0x206, edgeSkip},
+		{0x204, 0x200, edgeJump},
+	} {
+		if !edges[e] {
+			t.Fatalf("fatal flow error: expected edge %+v", e)
+		}
+	}
+	if len(g.Routines) != 2 || g.Routines[0] != 0x200 || len(g.Calls) != 1 || g.Calls[0].To != 0x20A {
+		t.Fatalf("fatal flow error: expected main calling 0x20A, got routines %X calls %+v", g.Routines, g.Calls)
+	}
+	if !g.Blocks[3].Indirect || g.resolved(0x206) {
+		t.Fatalf("fatal flow error: expected the BNNN block flagged and unresolved")
+	}
+
+	// running it shows where the BNNN lands
+	c.v[0] = 0xFF
+	c.pc = 0x206
+	indirect := traceIndirect(c, 4)
+	g = buildFlowGraph(&mem, standardLayout, len(program), nil, indirect)
+	if !g.resolved(0x206) || g.Blocks[len(g.Blocks)-1].Start != 0x20E {
+		t.Fatalf("fatal flow error: expected the trace to resolve the BNNN to 0x20E, got %v", indirect)
+	}
+
+	out := &bytes.Buffer{}
+	g.writeDOT(out)
+	if !strings.Contains(out.String(), "b206 -> b20E [color=red label=\"indirect\"];") {
+		t.Fatalf("fatal flow error: expected an indirect edge in the dot output, got\n%s", out.String())
+	}
+}
+
+func TestTraceIndirectRunsOffMemory(t *testing.T) {
+	program := []byte{
+		0xB2, 0x04, // 0x200: jump v0 + 0x204
+		0xAF, 0xFF, // 0x202: never run
+		0xAF, 0xFF, // 0x204: i = 0xFFF
+		0xF2, 0x55, // 0x206: store v0-v2 past the end of memory
+	}
+	c := &cpu{}
+	if err := c.init(program); err != nil {
+		t.Fatal(err)
+	}
+	targets := traceIndirect(c, 10)
+	if len(targets[0x200]) != 1 || targets[0x200][0] != 0x204 {
+		t.Fatalf("fatal flow error: expected the jump traced before the store, got %v", targets)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// glyphs for hex digits 0-F, five rows of four pixels each
+const fontSize = 16 * 5
+
+// character sprites used by chip8 programs
+var sprites = []uint8{
+	0xF0, 0x90, 0x90, 0x90, 0xF0, // 0
+	0x20, 0x60, 0x20, 0x20, 0x70, // 1
+	0xF0, 0x10, 0xF0, 0x80, 0xF0, // 2
+	0xF0, 0x10, 0xF0, 0x10, 0xF0, // 3
+	0x90, 0x90, 0xF0, 0x10, 0x10, // 4
+	0xF0, 0x80, 0xF0, 0x10, 0xF0, // 5
+	0xF0, 0x80, 0xF0, 0x90, 0xF0, // 6
+	0xF0, 0x10, 0x20, 0x40, 0x40, // 7
+	0xF0, 0x90, 0xF0, 0x90, 0xF0, // 8
+	0xF0, 0x90, 0xF0, 0x10, 0xF0, // 9
+	0xF0, 0x90, 0xF0, 0x90, 0x90, // A
+	0xE0, 0x90, 0xE0, 0x90, 0xE0, // B
+	0xF0, 0x80, 0x80, 0x80, 0xF0, // C
+	0xE0, 0x90, 0x90, 0x90, 0xE0, // D
+	0xF0, 0x80, 0xF0, 0x80, 0xF0, // E
+	0xF0, 0x80, 0xF0, 0x80, 0x80, // F
+}
+
+// fonts of historical interpreters, by name
+var fonts = map[string][]uint8{
+	"chip8": sprites,
+
+	// SCHIP's small font is the one most modern interpreters copied
+	"schip": sprites,
+
+	"vip": {
+		0xF0, 0x90, 0x90, 0x90, 0xF0, // 0
+		0x60, 0x20, 0x20, 0x20, 0x70, // 1
+		0xF0, 0x10, 0xF0, 0x80, 0xF0, // 2
+		0xF0, 0x10, 0xF0, 0x10, 0xF0, // 3
+		0xA0, 0xA0, 0xF0, 0x20, 0x20, // 4
+		0xF0, 0x80, 0xF0, 0x10, 0xF0, // 5
+		0xF0, 0x80, 0xF0, 0x90, 0xF0, // 6
+		0xF0, 0x10, 0x10, 0x10, 0x10, // 7
+		0xF0, 0x90, 0xF0, 0x90, 0xF0, // 8
+		0xF0, 0x90, 0xF0, 0x10, 0xF0, // 9
+		0xF0, 0x90, 0xF0, 0x90, 0x90, // A
+		0xF0, 0x50, 0x70, 0x50, 0xF0, // B
+		0xF0, 0x80, 0x80, 0x80, 0xF0, // C
+		0xF0, 0x50, 0x50, 0x50, 0xF0, // D
+		0xF0, 0x80, 0xF0, 0x80, 0xF0, // E
+		0xF0, 0x80, 0xF0, 0x80, 0x80, // F
+	},
+
+	"dream6800": {
+		0xE0, 0xA0, 0xA0, 0xA0, 0xE0, // 0
+		0x40, 0x40, 0x40, 0x40, 0x40, // 1
+		0xE0, 0x20, 0xE0, 0x80, 0xE0, // 2
+		0xE0, 0x20, 0xE0, 0x20, 0xE0, // 3
+		0x80, 0xA0, 0xA0, 0xE0, 0x20, // 4
+		0xE0, 0x80, 0xE0, 0x20, 0xE0, // 5
+		0xE0, 0x80, 0xE0, 0xA0, 0xE0, // 6
+		0xE0, 0x20, 0x20, 0x20, 0x20, // 7
+		0xE0, 0xA0, 0xE0, 0xA0, 0xE0, // 8
+		0xE0, 0xA0, 0xE0, 0x20, 0xE0, // 9
+		0xE0, 0xA0, 0xE0, 0xA0, 0xA0, // A
+		0xC0, 0xA0, 0xE0, 0xA0, 0xC0, // B
+		0xE0, 0x80, 0x80, 0x80, 0xE0, // C
+		0xC0, 0xA0, 0xA0, 0xA0, 0xC0, // D
+		0xE0, 0x80, 0xE0, 0x80, 0xE0, // E
+		0xE0, 0x80, 0xC0, 0x80, 0x80, // F
+	},
+
+	"eti660": {
+		0xE0, 0xA0, 0xA0, 0xA0, 0xE0, // 0
+		0x20, 0x20, 0x20, 0x20, 0x20, // 1
+		0xE0, 0x20, 0xE0, 0x80, 0xE0, // 2
+		0xE0, 0x20, 0xE0, 0x20, 0xE0, // 3
+		0xA0, 0xA0, 0xE0, 0x20, 0x20, // 4
+		0xE0, 0x80, 0xE0, 0x20, 0xE0, // 5
+		0xE0, 0x80, 0xE0, 0xA0, 0xE0, // 6
+		0xE0, 0x20, 0x20, 0x20, 0x20, // 7
+		0xE0, 0xA0, 0xE0, 0xA0, 0xE0, // 8
+		0xE0, 0xA0, 0xE0, 0x20, 0xE0, // 9
+		0xE0, 0xA0, 0xE0, 0xA0, 0xA0, // A
+		0x80, 0x80, 0xE0, 0xA0, 0xE0, // B
+		0xE0, 0x80, 0x80, 0x80, 0xE0, // C
+		0x20, 0x20, 0xE0, 0xA0, 0xE0, // D
+		0xE0, 0x80, 0xE0, 0x80, 0xE0, // E
+		0xE0, 0x80, 0xC0, 0x80, 0x80, // F
+	},
+}
+
+func fontNames() []string {
+	names := make([]string, 0, len(fonts))
+	for name := range fonts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// look up a font by name, or read a custom one from a file of
+// 80 bytes holding the 5 byte glyphs for 0-F in order
+func loadFont(name string) ([]uint8, error) {
+	if f, ok := fonts[name]; ok {
+		return f, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("unknown font %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != fontSize {
+		return nil, fmt.Errorf("font %s: expected %d bytes, got %d", name, fontSize, len(data))
+	}
+	return data, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFonts(t *testing.T) {
+	for name, font := range fonts {
+		if len(font) != fontSize {
+			t.Fatalf("fatal font error for %s: expected %d bytes, got %d", name, fontSize, len(font))
+		}
+		for k, row := range font {
+			if row&0x0F != 0 {
+				t.Fatalf("fatal font error for %s: row %d of glyph %X is wider than four pixels", name, k%5, k/5)
+			}
+		}
+	}
+}
+
+func TestLoadFont(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "font.bin")
+	custom := make([]byte, fontSize)
+	custom[5*0xA] = 0x80
+	if err := ioutil.WriteFile(path, custom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	font, err := loadFont(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// FX29 finds the custom glyph at the configured base
+	c := &cpu{font: font, layout: layout{load: 0x200, entry: 0x200, font: 0x100}}
+	if err := c.init([]byte{0x61, 0x0A, 0xF1, 0x29}); err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < 2; k++ {
+		if err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.mem[c.i] != 0x80 {
+		t.Fatalf("fatal font error for %s: expected glyph A at 0x%X, got 0x%X", path, c.i, c.mem[c.i])
+	}
+
+	short := filepath.Join(dir, "short.bin")
+	if err := ioutil.WriteFile(short, custom[:40], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFont(short); err == nil {
+		t.Fatalf("fatal font error for %s: expected an error for 40 bytes", short)
+	}
+	if _, err := loadFont("fish"); err == nil {
+		t.Fatalf("fatal font error for fish: expected an unknown font error")
+	}
+}
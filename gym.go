@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+)
+
+// display as seen by an agent, one byte per pixel, 0 or 1
+type observation [32][64]uint8
+
+// reinforcement learning environment around a headless cpu,
+// in the shape of an OpenAI gym
+type env struct {
+	program   []byte
+	quirks    quirks
+	ipf       int   // instructions per frame
+	frameSkip int   // frames per step, the action held throughout
+	seed      int64 // random source seed, each reset starts over
+	reward    expr  // score, rewards are its change between steps
+	done      expr  // episode over when non-zero, never if nil
+	jit       bool  // run through the basic block recompiler
+
+	c     *cpu
+	keys  uint16 // chip8 keys held for this step, one bit per key
+	score int    // reward expression at the end of the last step
+	err   error  // why the episode ended early, if it did
+}
+
+// start a new episode
+func (e *env) Reset() observation {
+	e.c = &cpu{
+		rng:    rand.New(rand.NewSource(e.seed)),
+		quirks: e.quirks,
+	}
+	if e.jit {
+		e.c.jit = newRecompiler()
+	}
+	e.c.waitKeyPlugin = func() (uint8, bool) {
+		for k := uint8(0); k < 16; k++ {
+			if e.keys&(1<<k) != 0 {
+				return k, true
+			}
+		}
+		return 0, false
+	}
+	e.keys = 0
+	e.err = e.c.init(e.program)
+	e.score = 0
+	if e.reward != nil {
+		e.score = e.reward(e.c)
+	}
+	return observation(e.c.disp)
+}
+
+// hold the keys set in action for frameSkip frames, returning the
+// display, the change in score and whether the episode is over
+func (e *env) Step(action uint16) (observation, int, bool) {
+	if e.c == nil {
+		e.Reset()
+	}
+	if e.err != nil {
+		return observation(e.c.disp), 0, true
+	}
+
+	e.keys = action
+	for k := range e.c.keys {
+		e.c.keys[k] = uint8(action >> uint(k) & 1)
+	}
+	for f := 0; f < e.frameSkip; f++ {
+		if _, err := e.c.run(e.ipf); err != nil {
+			e.err = err
+			break
+		}
+	}
+
+	reward := 0
+	if e.reward != nil {
+		score := e.reward(e.c)
+		reward, e.score = score-e.score, score
+	}
+	done := e.err != nil || (e.done != nil && e.done(e.c) != 0)
+	return observation(e.c.disp), reward, done
+}
+
+// gym protocol, one json object per line each way
+//
+//	{"cmd": "info"}               {"width": 64, "height": 32, "keys": 16, "frameskip": 4}
+//	{"cmd": "reset"}              {"obs": "..."}
+//	{"cmd": "step", "action": 18} {"obs": "...", "reward": 1, "done": false}
+//	{"cmd": "close"}              {}
+//
+// actions hold one bit per chip8 key, observations are the 2048 display
+// bytes row by row, base64 encoded. failures answer {"error": "..."}.
+type gymRequest struct {
+	Cmd    string `json:"cmd"`
+	Action uint16 `json:"action"`
+}
+
+type gymResponse struct {
+	Obs       []byte `json:"obs,omitempty"`
+	Reward    int    `json:"reward"`
+	Done      bool   `json:"done"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Keys      int    `json:"keys,omitempty"`
+	FrameSkip int    `json:"frameskip,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (o *observation) bytes() []byte {
+	out := make([]byte, 0, 32*64)
+	for y := range o {
+		out = append(out, o[y][:]...)
+	}
+	return out
+}
+
+// answer requests until close or end of input
+func (e *env) serve(r io.Reader, w io.Writer) error {
+	in := bufio.NewScanner(r)
+	out := json.NewEncoder(w)
+	for in.Scan() {
+		var req gymRequest
+		var res gymResponse
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			res.Error = err.Error()
+			if err := out.Encode(res); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch req.Cmd {
+		case "info":
+			res.Width, res.Height, res.Keys, res.FrameSkip = 64, 32, 16, e.frameSkip
+		case "reset":
+			obs := e.Reset()
+			res.Obs = obs.bytes()
+			if e.err != nil {
+				res.Error = e.err.Error()
+			}
+		case "step":
+			obs, reward, done := e.Step(req.Action)
+			res.Obs, res.Reward, res.Done = obs.bytes(), reward, done
+			if e.err != nil {
+				res.Error = e.err.Error()
+			}
+		case "close":
+			return out.Encode(res)
+		default:
+			res.Error = fmt.Sprintf("unknown cmd %q", req.Cmd)
+		}
+		if err := out.Encode(res); err != nil {
+			return err
+		}
+	}
+	return in.Err()
+}
+
+// chip8 gym [-listen unix:path|tcp:addr] [flags] rom
+func gymCmd(args []string) error {
+	fs := flag.NewFlagSet("gym", flag.ContinueOnError)
+	listen := fs.String("listen", "", "serve on unix:path or tcp:host:port instead of stdio")
+	ipf := fs.Int("ipf", defaultIPF, "instructions per 60Hz frame")
+	frameSkip := fs.Int("frameskip", 4, "frames per step")
+	seed := fs.Int64("seed", 1, "random source seed")
+	quirksName := fs.String("quirks", "chip8", "platform quirks: "+strings.Join(quirkPresetNames(), ", "))
+	rewardExpr := fs.String("reward", "", "score expression, rewards are its change per step")
+	doneExpr := fs.String("done", "", "expression ending the episode when non-zero")
+	jit := fs.Bool("jit", false, "run through the basic block recompiler")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("gym: expected one rom, got %d", fs.NArg())
+	}
+	if *ipf <= 0 || *frameSkip <= 0 {
+		return fmt.Errorf("gym: ipf and frameskip must be positive")
+	}
+
+	r, err := loadROM(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	program := r.data
+	q, err := parseQuirks(*quirksName)
+	if err != nil {
+		return err
+	}
+	e := &env{program: program, quirks: q, ipf: *ipf, frameSkip: *frameSkip, seed: *seed, jit: *jit}
+	if *rewardExpr != "" {
+		if e.reward, err = parseExpr(*rewardExpr); err != nil {
+			return err
+		}
+	}
+	if *doneExpr != "" {
+		if e.done, err = parseExpr(*doneExpr); err != nil {
+			return err
+		}
+	}
+
+	// stdout carries the protocol
+	log.SetOutput(ioutil.Discard)
+
+	if *listen == "" {
+		return e.serve(os.Stdin, os.Stdout)
+	}
+	parts := strings.SplitN(*listen, ":", 2)
+	if len(parts) != 2 || (parts[0] != "unix" && parts[0] != "tcp") {
+		return fmt.Errorf("gym: listen on unix:path or tcp:host:port, got %q", *listen)
+	}
+	l, err := net.Listen(parts[0], parts[1])
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	// one client at a time, each connection gets a fresh episode
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		e.c = nil
+		err = e.serve(conn, conn)
+		conn.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// adds one point per frame while key 5 is held, the display wait
+// ending each frame at the draw
+var scorer = []byte{
+	0x60, 0x05, // 0x200: v0 = 5
+	0xA3, 0x10, // 0x202: i = 0x310
+	0xD2, 0x21, // 0x204: draw a blank row
+	0xE0, 0xA1, // 0x206: if key v0 is up: skip
+	0x71, 0x01, // 0x208: v1 += 1
+	0xA3, 0x00, // 0x20A: i = 0x300
+	0xF1, 0x33, // 0x20C: bcd(0x300) = v1
+	0x12, 0x02, // 0x20E: loop
+}
+
+func scorerEnv(t *testing.T) *env {
+	reward, err := parseExpr("bcd(0x300)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := parseExpr("bcd(0x300) >= 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &env{program: scorer, quirks: quirks{wait: true}, ipf: 10, frameSkip: 2, seed: 1, reward: reward, done: done}
+}
+
+func TestEnv(t *testing.T) {
+	e := scorerEnv(t)
+	e.Reset()
+
+	if _, reward, done := e.Step(0); reward != 0 || done {
+		t.Fatalf("fatal gym error: expected nothing without keys, got reward %d (done %t)", reward, done)
+	}
+	if _, reward, done := e.Step(1 << 5); reward != 2 || done {
+		t.Fatalf("fatal gym error: expected reward 2 over two frames, got %d (done %t)", reward, done)
+	}
+	if _, reward, done := e.Step(1 << 5); reward != 2 || !done {
+		t.Fatalf("fatal gym error: expected reward 2 and game over, got %d (done %t)", reward, done)
+	}
+
+	// a reset starts the score over, the first frame only reaches the draw
+	e.Reset()
+	if _, reward, done := e.Step(1 << 5); reward != 1 || done {
+		t.Fatalf("fatal gym error: expected reward 1 after reset, got %d (done %t)", reward, done)
+	}
+}
+
+func TestEnvRunsOffMemory(t *testing.T) {
+	// i = 0xFFF, then store v0-v2 from there on, past the end of memory
+	e := &env{program: []byte{0xAF, 0xFF, 0xF2, 0x55, 0x12, 0x04}, ipf: 10, frameSkip: 1, seed: 1}
+	in := strings.NewReader(`{"cmd": "reset"}
+{"cmd": "step", "action": 0}
+{"cmd": "step", "action": 0}
+`)
+	out := &bytes.Buffer{}
+	if err := e.serve(in, out); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(out)
+	var responses []gymResponse
+	for dec.More() {
+		var res gymResponse
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, res)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("fatal gym error: expected 3 responses, got %d", len(responses))
+	}
+	for _, res := range responses[1:] {
+		if !res.Done || res.Error != (&trap{"i out of range", 0xFFF}).Error() {
+			t.Fatalf("fatal gym error for step: expected done with i out of range, got %+v", res)
+		}
+	}
+}
+
+func TestEnvServe(t *testing.T) {
+	e := scorerEnv(t)
+	in := strings.NewReader(`{"cmd": "info"}
+{"cmd": "reset"}
+{"cmd": "step", "action": 32}
+{"cmd": "jump"}
+{"cmd": "close"}
+{"cmd": "step", "action": 32}
+`)
+	out := &bytes.Buffer{}
+	if err := e.serve(in, out); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(out)
+	var responses []gymResponse
+	for dec.More() {
+		var res gymResponse
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, res)
+	}
+	if len(responses) != 5 {
+		t.Fatalf("fatal gym error: expected 5 responses up to close, got %d", len(responses))
+	}
+	if responses[0].Width != 64 || responses[0].FrameSkip != 2 {
+		t.Fatalf("fatal gym error for info: got %+v", responses[0])
+	}
+	if len(responses[1].Obs) != 32*64 {
+		t.Fatalf("fatal gym error for reset: expected %d bytes of observation, got %d", 32*64, len(responses[1].Obs))
+	}
+	if responses[2].Reward != 1 {
+		t.Fatalf("fatal gym error for step: expected reward 1, got %d", responses[2].Reward)
+	}
+	if responses[3].Error == "" {
+		t.Fatalf("fatal gym error for jump: expected an unknown cmd error")
+	}
+}
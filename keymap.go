@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/nsf/termbox-go"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// emulator actions that can be bound alongside the chip8 keys
+type action int
+
+const (
+	actionQuit action = iota + 1
+	actionPause
+	actionReset
+	actionSaveState
+	actionLoadState
+	actionFrameAdvance
+	actionFastForward
+	actionSlowMotion
+	actionMemory
+)
+
+var actionNames = map[string]action{
+	"quit":          actionQuit,
+	"pause":         actionPause,
+	"reset":         actionReset,
+	"save-state":    actionSaveState,
+	"load-state":    actionLoadState,
+	"frame-advance": actionFrameAdvance,
+	"fast-forward":  actionFastForward,
+	"slow-motion":   actionSlowMotion,
+	"memory":        actionMemory,
+}
+
+// host keys bound to chip8 keys and emulator actions, by key name
+// (names are lowercase characters such as "q" or "&", or one of
+// space, enter, escape, backspace, tab, f1-f12, kp0-kp9, kp-plus,
+// kp-minus, kp-multiply, kp-divide, kp-period, kp-enter, up, down, left, right)
+type keymap struct {
+	keys    map[string]uint8
+	actions map[string]action
+}
+
+// chip8 keypad rows, top to bottom
+var keypad = [4][4]uint8{
+	{0x1, 0x2, 0x3, 0xC},
+	{0x4, 0x5, 0x6, 0xD},
+	{0x7, 0x8, 0x9, 0xE},
+	{0xA, 0x0, 0xB, 0xF},
+}
+
+// host keys covering the keypad, row by row, for each preset
+var keymapPresets = map[string][4][4]string{
+	"qwerty": {
+		{"1", "2", "3", "4"},
+		{"q", "w", "e", "r"},
+		{"a", "s", "d", "f"},
+		{"z", "x", "c", "v"},
+	},
+	"azerty": {
+		{"&", "é", "\"", "'"},
+		{"a", "z", "e", "r"},
+		{"q", "s", "d", "f"},
+		{"w", "x", "c", "v"},
+	},
+	"dvorak": {
+		{"1", "2", "3", "4"},
+		{"'", ",", ".", "p"},
+		{"a", "o", "e", "u"},
+		{";", "q", "j", "k"},
+	},
+	"numpad": {
+		{"kp7", "kp8", "kp9", "kp-divide"},
+		{"kp4", "kp5", "kp6", "kp-multiply"},
+		{"kp1", "kp2", "kp3", "kp-minus"},
+		{"kp0", "kp-period", "kp-enter", "kp-plus"},
+	},
+}
+
+// actions bound in every preset
+var defaultActions = map[string]action{
+	"escape":    actionQuit,
+	"space":     actionPause,
+	"backspace": actionReset,
+	"f5":        actionSaveState,
+	"f9":        actionLoadState,
+	"f6":        actionFrameAdvance,
+	"tab":       actionFastForward,
+	"f7":        actionSlowMotion,
+	"f2":        actionMemory,
+}
+
+func keymapPresetNames() []string {
+	names := make([]string, 0, len(keymapPresets))
+	for name := range keymapPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func presetKeymap(name string) (*keymap, error) {
+	rows, ok := keymapPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown keymap preset %q", name)
+	}
+	km := &keymap{keys: map[string]uint8{}, actions: map[string]action{}}
+	for r := range rows {
+		for col, key := range rows[r] {
+			km.keys[key] = keypad[r][col]
+		}
+	}
+	for key, a := range defaultActions {
+		km.actions[key] = a
+	}
+	return km, nil
+}
+
+// chip8 key bound to a host key
+func (km *keymap) key(name string) (uint8, bool) {
+	k, ok := km.keys[name]
+	return k, ok
+}
+
+// emulator action bound to a host key
+func (km *keymap) action(name string) (action, bool) {
+	a, ok := km.actions[name]
+	return a, ok
+}
+
+// keymap file layout
+//
+//	{
+//	  "preset": "qwerty",
+//	  "keys": {"kp0": "0"},
+//	  "actions": {"f1": "pause", "space": ""},
+//	  "roms": {"pong.ch8": {"preset": "numpad"}}
+//	}
+//
+// keys map host keys to chip8 keys in hex and actions map host keys to
+// quit, pause, reset, save-state, load-state, frame-advance, fast-forward,
+// slow-motion or memory, an empty value unbinds.
+// roms hold the same settings applied over the top level for one rom,
+// looked up by file name or by the sha1 of the rom.
+type keymapConfig struct {
+	keymapSettings
+	ROMs map[string]keymapSettings `json:"roms"`
+}
+
+type keymapSettings struct {
+	Preset  string            `json:"preset"`
+	Keys    map[string]string `json:"keys"`
+	Actions map[string]string `json:"actions"`
+}
+
+// default keymap file, under the user's config directory
+func defaultKeymapPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "chip8", "keymap.json")
+}
+
+// build the keymap for a rom from a preset and an optional config file
+// (a missing file at the default path is not an error)
+func loadKeymap(preset, path, rom, hash string) (*keymap, error) {
+	cfg := keymapConfig{}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err) && path == defaultKeymapPath():
+		case err != nil:
+			return nil, err
+		default:
+			if err := json.Unmarshal(data, &cfg); err != nil {
+				return nil, fmt.Errorf("keymap %s: %s", path, err)
+			}
+		}
+	}
+
+	layers := []keymapSettings{cfg.keymapSettings}
+	if r, ok := cfg.ROMs[filepath.Base(rom)]; ok {
+		layers = append(layers, r)
+	}
+	if r, ok := cfg.ROMs[hash]; ok {
+		layers = append(layers, r)
+	}
+	return buildKeymap(preset, layers...)
+}
+
+// start from a preset, then apply each layer of settings in order
+func buildKeymap(preset string, layers ...keymapSettings) (*keymap, error) {
+	for _, l := range layers {
+		if l.Preset != "" {
+			preset = l.Preset
+		}
+	}
+	km, err := presetKeymap(preset)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range layers {
+		for key, value := range l.Keys {
+			key = strings.ToLower(key)
+			if value == "" {
+				delete(km.keys, key)
+				continue
+			}
+			k, err := strconv.ParseUint(value, 16, 4)
+			if err != nil {
+				return nil, fmt.Errorf("keymap: %q is not a chip8 key", value)
+			}
+			km.keys[key] = uint8(k)
+		}
+		for key, value := range l.Actions {
+			key = strings.ToLower(key)
+			if value == "" {
+				delete(km.actions, key)
+				continue
+			}
+			a, ok := actionNames[value]
+			if !ok {
+				return nil, fmt.Errorf("keymap: unknown action %q", value)
+			}
+			km.actions[key] = a
+		}
+	}
+	return km, nil
+}
+
+// names for control characters that double as keys
+var charKeyNames = map[rune]string{
+	' ':    "space",
+	'\r':   "enter",
+	'\x1b': "escape",
+	'\b':   "backspace",
+	'\x7f': "backspace",
+	'\t':   "tab",
+}
+
+func charKeyName(r rune) string {
+	if name, ok := charKeyNames[r]; ok {
+		return name
+	}
+	return string(unicode.ToLower(r))
+}
+
+// names for SDL keys without a character
+var sdlKeyNames = map[int]string{
+	sdl.SCANCODE_F1:          "f1",
+	sdl.SCANCODE_F2:          "f2",
+	sdl.SCANCODE_F3:          "f3",
+	sdl.SCANCODE_F4:          "f4",
+	sdl.SCANCODE_F5:          "f5",
+	sdl.SCANCODE_F6:          "f6",
+	sdl.SCANCODE_F7:          "f7",
+	sdl.SCANCODE_F8:          "f8",
+	sdl.SCANCODE_F9:          "f9",
+	sdl.SCANCODE_F10:         "f10",
+	sdl.SCANCODE_F11:         "f11",
+	sdl.SCANCODE_F12:         "f12",
+	sdl.SCANCODE_KP_0:        "kp0",
+	sdl.SCANCODE_KP_1:        "kp1",
+	sdl.SCANCODE_KP_2:        "kp2",
+	sdl.SCANCODE_KP_3:        "kp3",
+	sdl.SCANCODE_KP_4:        "kp4",
+	sdl.SCANCODE_KP_5:        "kp5",
+	sdl.SCANCODE_KP_6:        "kp6",
+	sdl.SCANCODE_KP_7:        "kp7",
+	sdl.SCANCODE_KP_8:        "kp8",
+	sdl.SCANCODE_KP_9:        "kp9",
+	sdl.SCANCODE_KP_PLUS:     "kp-plus",
+	sdl.SCANCODE_KP_MINUS:    "kp-minus",
+	sdl.SCANCODE_KP_MULTIPLY: "kp-multiply",
+	sdl.SCANCODE_KP_DIVIDE:   "kp-divide",
+	sdl.SCANCODE_KP_PERIOD:   "kp-period",
+	sdl.SCANCODE_KP_ENTER:    "kp-enter",
+	sdl.SCANCODE_UP:          "up",
+	sdl.SCANCODE_DOWN:        "down",
+	sdl.SCANCODE_LEFT:        "left",
+	sdl.SCANCODE_RIGHT:       "right",
+}
+
+// name of an SDL key, by the character it types in the current layout
+func sdlKeyName(ks sdl.Keysym) string {
+	// keypad keys would otherwise read as the characters they type
+	if name, ok := sdlKeyNames[int(ks.Scancode)]; ok {
+		return name
+	}
+	if ks.Sym&(1<<30) == 0 {
+		return charKeyName(rune(ks.Sym))
+	}
+	return ""
+}
+
+// names for termbox keys without a character
+var termboxKeyNames = map[termbox.Key]string{
+	termbox.KeyF1:         "f1",
+	termbox.KeyF2:         "f2",
+	termbox.KeyF3:         "f3",
+	termbox.KeyF4:         "f4",
+	termbox.KeyF5:         "f5",
+	termbox.KeyF6:         "f6",
+	termbox.KeyF7:         "f7",
+	termbox.KeyF8:         "f8",
+	termbox.KeyF9:         "f9",
+	termbox.KeyF10:        "f10",
+	termbox.KeyF11:        "f11",
+	termbox.KeyF12:        "f12",
+	termbox.KeyArrowUp:    "up",
+	termbox.KeyArrowDown:  "down",
+	termbox.KeyArrowLeft:  "left",
+	termbox.KeyArrowRight: "right",
+}
+
+// name of a termbox key event
+// (terminals report keypad keys as the characters they type)
+func termboxKeyName(ev termbox.Event) string {
+	if ev.Ch != 0 {
+		return charKeyName(ev.Ch)
+	}
+	if name, ok := termboxKeyNames[ev.Key]; ok {
+		return name
+	}
+	return charKeyName(rune(ev.Key))
+}
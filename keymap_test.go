@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeymapPresets(t *testing.T) {
+	cases := []struct {
+		preset   string
+		name     string
+		expected uint8
+	}{
+		{"qwerty", "1", 0x1},
+		{"qwerty", "v", 0xF},
+		{"azerty", "é", 0x2},
+		{"azerty", "w", 0xA},
+		{"dvorak", "o", 0x8},
+		{"numpad", "kp-period", 0x0},
+		{"numpad", "kp-divide", 0xC},
+	}
+	for _, tc := range cases {
+		km, err := presetKeymap(tc.preset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := km.key(tc.name); !ok || got != tc.expected {
+			t.Fatalf("fatal keymap error for %s %q: expected 0x%X, got 0x%X (bound %t)", tc.preset, tc.name, tc.expected, got, ok)
+		}
+		if a, ok := km.action("escape"); !ok || a != actionQuit {
+			t.Fatalf("fatal keymap error for %s: escape is not bound to quit", tc.preset)
+		}
+	}
+}
+
+func TestLoadKeymap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keymap.json")
+	config := `{
+		"preset": "qwerty",
+		"keys": {"p": "c", "4": ""},
+		"actions": {"escape": "", "f1": "pause"},
+		"roms": {"pong.ch8": {"preset": "dvorak"}}
+	}`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := loadKeymap("qwerty", path, "roms/pong.ch8", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the rom switches layout, the top level overrides still apply
+	if k, ok := km.key("o"); !ok || k != 0x8 {
+		t.Fatalf("fatal keymap error: expected dvorak o bound to 0x8, got 0x%X (bound %t)", k, ok)
+	}
+	if k, ok := km.key("p"); !ok || k != 0xC {
+		t.Fatalf("fatal keymap error: expected p bound to 0xC, got 0x%X (bound %t)", k, ok)
+	}
+	if _, ok := km.key("4"); ok {
+		t.Fatalf("fatal keymap error: expected 4 unbound")
+	}
+	if _, ok := km.action("escape"); ok {
+		t.Fatalf("fatal keymap error: expected escape unbound")
+	}
+	if a, ok := km.action("f1"); !ok || a != actionPause {
+		t.Fatalf("fatal keymap error: expected f1 bound to pause")
+	}
+
+	if _, err := buildKeymap("qwerty", keymapSettings{Keys: map[string]string{"q": "g"}}); err == nil {
+		t.Fatalf("fatal keymap error: expected an error for chip8 key g")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// where the program and font live in memory
+type layout struct {
+	load  uint16 // address the program is copied to
+	entry uint16 // initial programme counter
+	font  uint16 // address of the hex font
+}
+
+// the COSMAC VIP layout most programs expect
+var standardLayout = layout{load: 0x200, entry: 0x200, font: 0x000}
+
+var layouts = map[string]layout{
+	"chip8":  standardLayout,
+	"eti660": {load: 0x600, entry: 0x600, font: 0x000},
+}
+
+func layoutNames() []string {
+	names := make([]string, 0, len(layouts))
+	for name := range layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseLayout(name string) (layout, error) {
+	l, ok := layouts[name]
+	if !ok {
+		return layout{}, fmt.Errorf("unknown memory layout %q", name)
+	}
+	return l, nil
+}
+
+// the layout for a rom database platform: a layout name, or a quirks
+// preset or community database platform id, all loading where the VIP did
+func platformLayout(name string) (layout, error) {
+	if l, ok := layouts[name]; ok {
+		return l, nil
+	}
+	if _, err := parseQuirks(name); err == nil {
+		return standardLayout, nil
+	}
+	return layout{}, fmt.Errorf("unknown platform %q", name)
+}
+
+// parse a memory address, in hex with a 0x prefix or decimal
+func parseAddr(s string) (uint16, error) {
+	addr, err := strconv.ParseUint(s, 0, 16)
+	if err != nil || addr >= 4096 {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint16(addr), nil
+}
+
+// replace addresses given as strings, the entry point follows a
+// new load address unless given too
+func (l *layout) override(load, entry, font string) error {
+	if load != "" {
+		addr, err := parseAddr(load)
+		if err != nil {
+			return err
+		}
+		if l.entry == l.load {
+			l.entry = addr
+		}
+		l.load = addr
+	}
+	if entry != "" {
+		addr, err := parseAddr(entry)
+		if err != nil {
+			return err
+		}
+		l.entry = addr
+	}
+	if font != "" {
+		addr, err := parseAddr(font)
+		if err != nil {
+			return err
+		}
+		l.font = addr
+	}
+	return nil
+}
+
+// check the program and font both fit without overlapping
+func (l layout) check(program, font []byte) error {
+	if int(l.load)+len(program) > 4096 {
+		return fmt.Errorf("program of %d bytes does not fit at 0x%03X, %d bytes free", len(program), l.load, 4096-int(l.load))
+	}
+	if int(l.font)+len(font) > 4096 {
+		return fmt.Errorf("font of %d bytes does not fit at 0x%03X", len(font), l.font)
+	}
+	if int(l.font) < int(l.load)+len(program) && int(l.load) < int(l.font)+len(font) {
+		return fmt.Errorf("font at 0x%03X overlaps the program at 0x%03X", l.font, l.load)
+	}
+	if int(l.entry)+2 > 4096 {
+		return fmt.Errorf("entry point 0x%03X leaves no room for an instruction", l.entry)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLayoutETI660(t *testing.T) {
+	c := &cpu{layout: layouts["eti660"]}
+	if err := c.init([]byte{0x61, 0x0A, 0xF1, 0x29}); err != nil {
+		t.Fatal(err)
+	}
+	if c.pc != 0x600 || c.mem[0x600] != 0x61 || c.mem[0x200] != 0x00 {
+		t.Fatalf("fatal layout error for eti660: expected the program at 0x600, got pc 0x%X", c.pc)
+	}
+	for k := 0; k < 2; k++ {
+		if err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.i != 50 {
+		t.Fatalf("fatal layout error for eti660: expected i 50 for sprite A, got %d", c.i)
+	}
+}
+
+func TestLayoutFontAddr(t *testing.T) {
+	l := standardLayout
+	if err := l.override("", "", "0x50"); err != nil {
+		t.Fatal(err)
+	}
+	c := &cpu{layout: l}
+	if err := c.init([]byte{0x61, 0x0A, 0xF1, 0x29}); err != nil {
+		t.Fatal(err)
+	}
+	if c.mem[0x50] != sprites[0] || c.mem[0] != 0x00 {
+		t.Fatalf("fatal layout error for font-addr: expected the font at 0x50")
+	}
+	for k := 0; k < 2; k++ {
+		if err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.i != 0x50+50 {
+		t.Fatalf("fatal layout error for font-addr: expected i 0x%X for sprite A, got 0x%X", 0x50+50, c.i)
+	}
+}
+
+func TestLayoutCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		load    string
+		entry   string
+		font    string
+		size    int
+		wantErr bool
+	}{
+		{"standard", "", "", "", 4096 - 0x200, false},
+		{"too big", "", "", "", 4096 - 0x200 + 1, true},
+		{"eti660 too big", "0x600", "", "", 4096 - 0x200, true},
+		{"entry follows load", "0x300", "", "", 2, false},
+		{"font overlaps", "", "", "0x1F0", 2, true},
+		{"font off the end", "", "", "0xFF0", 2, true},
+		{"bad address", "0x1000", "", "", 2, true},
+	}
+
+	for _, test := range tests {
+		l := standardLayout
+		err := l.override(test.load, test.entry, test.font)
+		if err == nil {
+			err = l.check(make([]byte, test.size), sprites)
+		}
+		if (err != nil) != test.wantErr {
+			t.Fatalf("fatal layout error for %s: expected error %t, got %v", test.name, test.wantErr, err)
+		}
+		if test.name == "entry follows load" && l.entry != 0x300 {
+			t.Fatalf("fatal layout error for %s: expected entry 0x300, got 0x%X", test.name, l.entry)
+		}
+	}
+}
@@ -1,39 +1,20 @@
 package main
 
 import (
-	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"runtime"
+	"strings"
 	"time"
 
-	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-// character sprites used by chip8 programs
-var sprites = []uint8{
-	0xF0, 0x90, 0x90, 0x90, 0xF0, // 0
-	0x20, 0x60, 0x20, 0x20, 0x70, // 1
-	0xF0, 0x10, 0xF0, 0x80, 0xF0, // 2
-	0xF0, 0x10, 0xF0, 0x10, 0xF0, // 3
-	0x90, 0x90, 0xF0, 0x10, 0x10, // 4
-	0xF0, 0x80, 0xF0, 0x10, 0xF0, // 5
-	0xF0, 0x80, 0xF0, 0x90, 0xF0, // 6
-	0xF0, 0x10, 0x20, 0x40, 0x40, // 7
-	0xF0, 0x90, 0xF0, 0x90, 0xF0, // 8
-	0xF0, 0x90, 0xF0, 0x10, 0xF0, // 9
-	0xF0, 0x90, 0xF0, 0x90, 0x90, // A
-	0xE0, 0x90, 0xE0, 0x90, 0xE0, // B
-	0xF0, 0x80, 0x80, 0x80, 0xF0, // C
-	0xE0, 0x90, 0x90, 0x90, 0xE0, // D
-	0xF0, 0x80, 0xF0, 0x80, 0xF0, // E
-	0xF0, 0x80, 0xF0, 0x80, 0x80, // F
-}
-
 type cpu struct {
 	mem   [4096]uint8   // memory
 	pc    uint16        // programme counter
@@ -45,49 +26,52 @@ type cpu struct {
 	stack [16]uint16    // stack
 	keys  [16]uint8     // keyboard
 	disp  [32][64]uint8 // display
+	dirty uint32        // display rows changed since the last present, one bit per row
+	drew  bool          // has DXYN run since the start of this run?
+
+	// emulator
+	trace   bool        // log every executed instruction
+	rng     *rand.Rand  // random source for CXKK, global source if nil
+	jit     *recompiler // basic block cache, interpreter only if nil
+	audio   audio       // sound output, silent if nil
+	video   video       // frame output
+	quirks  quirks      // platform behaviours
+	layout  layout      // program and font addresses
+	font    []uint8     // hex font glyphs, sprites if nil
+	paused  bool        // skip execution, keep presenting
+	advance bool        // run the next frame even if paused
+	speed   float64     // emulated frames per 60Hz tick, 1 if zero
+	prof    *profile    // execution counts, interpreter only, not collected if nil
+	cheats  *cheats     // patches, frozen values and memory search, none if nil
+	notice  string      // brief message for the status line
+	noticed int         // frames left to show the notice
+
+	// plugins
+	waitKeyPlugin func() (uint8, bool) // block for a key press, false to retry later
 }
 
-var sdlKeyMap = map[int]byte{
-	sdl.SCANCODE_1: 0x1,
-	sdl.SCANCODE_2: 0x2,
-	sdl.SCANCODE_3: 0x3,
-	sdl.SCANCODE_4: 0xC,
-	sdl.SCANCODE_Q: 0x4,
-	sdl.SCANCODE_W: 0x5,
-	sdl.SCANCODE_E: 0x6,
-	sdl.SCANCODE_R: 0xD,
-	sdl.SCANCODE_A: 0x7,
-	sdl.SCANCODE_S: 0x8,
-	sdl.SCANCODE_D: 0x9,
-	sdl.SCANCODE_F: 0xE,
-	sdl.SCANCODE_Z: 0xA,
-	sdl.SCANCODE_X: 0x0,
-	sdl.SCANCODE_C: 0xB,
-	sdl.SCANCODE_V: 0xF,
-	sdl.SCANCODE_0: 0x10,
-}
-
-func pollKeys(k []uint8, kill *bool) {
+// drain pending SDL events into the keyboard state, handing bound actions on
+func pumpKeys(km *keymap, k []uint8, onAction func(a action)) {
 	for {
-		if *kill {
-			return
-		}
 		e := sdl.PollEvent()
 		switch ev := e.(type) {
+		case nil:
+			return
+		case *sdl.QuitEvent:
+			onAction(actionQuit)
 		case *sdl.KeyboardEvent:
+			name := sdlKeyName(ev.Keysym)
 			switch ev.Type {
 			case sdl.KEYDOWN:
-				key := int(ev.Keysym.Scancode)
-				if i, ok := sdlKeyMap[key]; ok {
-					if i == 0x10 {
-						*kill = true
-						return
+				if a, ok := km.action(name); ok {
+					if ev.Repeat == 0 {
+						onAction(a)
 					}
+				} else if i, ok := km.key(name); ok {
 					k[i] = 1
 				}
 			case sdl.KEYUP:
-				key := int(ev.Keysym.Scancode)
-				if i, ok := sdlKeyMap[key]; ok {
+				if i, ok := km.key(name); ok {
 					k[i] = 0
 				}
 			default:
@@ -99,79 +83,129 @@ func pollKeys(k []uint8, kill *bool) {
 	}
 }
 
-// halt until any key is pressed, return key value
-func getKey(pollEventPlugin func() termbox.Event) uint8 {
+// halt until a bound key is pressed, return key value
+// (gives up with ok false as soon as an emulator action is waiting)
+func sdlWaitKey(km *keymap, pending func() bool, onAction func(a action)) func() (uint8, bool) {
+	return func() (uint8, bool) {
+		for !pending() {
+			e := sdl.PollEvent()
+			switch ev := e.(type) {
+			case nil:
+				time.Sleep(time.Millisecond)
+			case *sdl.QuitEvent:
+				onAction(actionQuit)
+			case *sdl.KeyboardEvent:
+				if ev.Type != sdl.KEYDOWN {
+					continue
+				}
+				name := sdlKeyName(ev.Keysym)
+				if a, ok := km.action(name); ok {
+					onAction(a)
+				} else if i, ok := km.key(name); ok {
+					return i, true
+				}
+			}
+		}
+		return 0, false
+	}
+}
+
+// termbox events read in the background, so frames never wait for input
+type termInput struct {
+	events chan termbox.Event
+	typed  []uint8 // chip8 keys typed between frames, for the next key wait
+}
+
+func newTermInput() *termInput {
+	in := &termInput{events: make(chan termbox.Event, 64)}
+	go func() {
+		for {
+			in.events <- termbox.PollEvent()
+		}
+	}()
+	return in
+}
+
+// route a key to the memory panel, an action or the chip8 keys
+// (terminals report no key releases, so chip8 keys are kept for FX0A)
+func (in *termInput) handle(ev termbox.Event, km *keymap, view *memView, onAction func(a action)) {
+	if ev.Type != termbox.EventKey {
+		return
+	}
+	name := termboxKeyName(ev)
+	if view != nil && view.key(name) {
+		return
+	}
+	if a, ok := km.action(name); ok {
+		onAction(a)
+	} else if i, ok := km.key(name); ok && len(in.typed) < 16 {
+		in.typed = append(in.typed, i)
+	}
+}
+
+// handle whatever was typed since the last frame
+func (in *termInput) pump(km *keymap, view *memView, onAction func(a action)) {
 	for {
-		ev := pollEventPlugin()
-		switch ev.Ch {
-		case '1':
-			return 0x1
-		case '2':
-			return 0x2
-		case '3':
-			return 0x3
-		case '4':
-			return 0xC
-		case 'q':
-			return 0x4
-		case 'w':
-			return 0x5
-		case 'e':
-			return 0x6
-		case 'r':
-			return 0xD
-		case 'a':
-			return 0x7
-		case 's':
-			return 0x8
-		case 'd':
-			return 0x9
-		case 'f':
-			return 0xE
-		case 'z':
-			return 0xA
-		case 'x':
-			return 0x0
-		case 'c':
-			return 0xB
-		case 'v':
-			return 0xF
+		select {
+		case ev := <-in.events:
+			in.handle(ev, km, view, onAction)
 		default:
-			continue
+			return
 		}
 	}
 }
 
-// print pixel to display
-// (configured explicitly for termbox)
-func (c *cpu) draw(x, y uint8, r rune, f func(x, y int, c rune, fg, bg termbox.Attribute)) {
-	wideX := int(x * 2)
-	wideY := int(y)
-	f(wideX, wideY, r, termbox.ColorGreen, termbox.ColorDefault)
-	f(wideX+runewidth.RuneWidth(r), wideY, r, termbox.ColorGreen, termbox.ColorDefault)
+// halt until a bound key is typed in the terminal, return key value
+// (gives up with ok false as soon as an emulator action is waiting)
+func (in *termInput) waitKey(km *keymap, pending func() bool, onAction func(a action)) func() (uint8, bool) {
+	return func() (uint8, bool) {
+		for !pending() {
+			if len(in.typed) > 0 {
+				i := in.typed[0]
+				in.typed = in.typed[1:]
+				return i, true
+			}
+			in.handle(<-in.events, km, nil, onAction)
+		}
+		return 0, false
+	}
 }
 
 // set initial state, prerequisite for all program execution
-func (c *cpu) init(program []byte) {
+func (c *cpu) init(program []byte) error {
+	// the zero layout is the standard one
+	if c.layout == (layout{}) {
+		c.layout = standardLayout
+	}
+	if c.font == nil {
+		c.font = sprites
+	}
+	if err := c.layout.check(program, c.font); err != nil {
+		return err
+	}
+
 	// load sprites into RAM
-	copy(c.mem[0:], sprites)
+	copy(c.mem[c.layout.font:], c.font)
 
 	// load game into RAM
-	copy(c.mem[0x0200:], []uint8(program))
+	copy(c.mem[c.layout.load:], []uint8(program))
 
 	// set program counter
-	c.pc = 0x0200
+	c.pc = c.layout.entry
 
 	// set stack pointer
 	c.sp = 0x00
+
+	// present the whole display on the first frame
+	c.dirty = allRows
+	return nil
 }
 
-// fetch and execute single opcode
+// run 60Hz frames until killed
 func (c *cpu) cycle(
-	drawPlugin func(x, y int, c rune, fg, bg termbox.Attribute),
-	flushPlugin func() error,
+	ipf int,
+	pumpPlugin func(),
 	sleepPlugin func(d time.Duration),
-	pollEventPlugin func() termbox.Event,
 	kill *bool,
 ) {
 	for {
@@ -179,27 +213,25 @@ func (c *cpu) cycle(
 		if *kill {
 			return
 		}
+		start := time.Now()
 
-		// decrement delay timer
-		if c.dt > 0 {
-			c.dt -= 1
-		}
+		// input
+		pumpPlugin()
 
-		// decrement sound timer
-		if c.st > 0 {
-			c.st -= 1
+		// fast-forward runs extra frames unseen, slow motion stretches the tick
+		frames, period := 1, time.Second/60
+		if c.speed > 1 {
+			frames = int(c.speed)
+		} else if c.speed > 0 {
+			period = time.Duration(float64(period) / c.speed)
+		}
+		var err error
+		for k := 1; k < frames && err == nil; k++ {
+			err = c.emulate(ipf)
+		}
+		if err == nil {
+			err = c.frame(ipf)
 		}
-
-		// fetch opcode
-		opcode := c.fetch()
-
-		// exec opcode
-		err := c.exec(
-			opcode,
-			drawPlugin,
-			flushPlugin,
-			pollEventPlugin,
-		)
 		if err != nil {
 			log.Print(err)
 			*kill = true
@@ -207,10 +239,125 @@ func (c *cpu) cycle(
 		}
 
 		// run at rate of 60Hz
-		sleepPlugin(3 * time.Millisecond)
+		sleepPlugin(period - time.Since(start))
 	}
 }
 
+// execute one 60Hz frame worth of instructions, then present it
+func (c *cpu) frame(ipf int) error {
+	if err := c.emulate(ipf); err != nil {
+		return err
+	}
+	if c.video != nil {
+		if sv, ok := c.video.(statusVideo); ok {
+			if err := sv.status(c.status()); err != nil {
+				return err
+			}
+		}
+		if err := c.video.present(&c.disp, c.dirty); err != nil {
+			return err
+		}
+		c.dirty = 0
+	}
+	if c.noticed > 0 {
+		c.noticed--
+	}
+	return nil
+}
+
+// execute one 60Hz frame worth of instructions and sound, unless paused
+// (a pending frame advance runs a single frame while paused)
+func (c *cpu) emulate(ipf int) error {
+	running := !c.paused || c.advance
+	c.advance = false
+	if running {
+		if c.cheats != nil {
+			c.cheats.hold(c)
+		}
+		if _, err := c.run(ipf); err != nil {
+			return err
+		}
+	}
+	if c.audio != nil {
+		if err := c.audio.frame(c.st > 0 && running); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// one line describing how the emulator is running, empty at normal speed
+func (c *cpu) status() string {
+	switch {
+	case c.paused:
+		return "PAUSED"
+	case c.speed > 1:
+		return fmt.Sprintf("FAST x%d", int(c.speed))
+	case c.speed > 0 && c.speed < 1:
+		return fmt.Sprintf("SLOW x1/%d", int(1/c.speed+0.5))
+	case c.noticed > 0:
+		return c.notice
+	}
+	return ""
+}
+
+// frames a notice stays in the status line
+const noticeFrames = 120
+
+// show a message in the status line for a couple of seconds
+func (c *cpu) announce(text string) {
+	c.notice, c.noticed = text, noticeFrames
+}
+
+// decrement timers
+func (c *cpu) tick() {
+	// decrement delay timer
+	if c.dt > 0 {
+		c.dt -= 1
+	}
+
+	// decrement sound timer
+	if c.st > 0 {
+		c.st -= 1
+	}
+}
+
+// tick timers, then fetch and execute single opcode
+func (c *cpu) step() error {
+	if int(c.pc)+1 >= len(c.mem) {
+		return &trap{"pc out of range", c.pc}
+	}
+	c.tick()
+	return c.exec(c.fetch())
+}
+
+// execute at least n instructions, through compiled blocks when a
+// recompiler is attached, and return how many were executed
+func (c *cpu) run(n int) (int, error) {
+	done := 0
+	c.drew = false
+	for done < n {
+		if c.jit != nil {
+			k, err := c.jit.step(c)
+			done += k
+			if err != nil {
+				return done, err
+			}
+		} else {
+			done++
+			if err := c.step(); err != nil {
+				return done, err
+			}
+		}
+
+		// sprites are only drawn on a 60Hz boundary
+		if c.quirks.wait && c.drew {
+			break
+		}
+	}
+	return done, nil
+}
+
 // fetch next opcode and advance program counter
 func (c *cpu) fetch() uint16 {
 	// fetch opcode
@@ -224,317 +371,124 @@ func (c *cpu) fetch() uint16 {
 	return opcode
 }
 
-// execute opcode
-func (c *cpu) exec(
-	opcode uint16,
-	drawPlugin func(x, y int, c rune, fg, bg termbox.Attribute),
-	flushPlugin func() error,
-	pollEventPlugin func() termbox.Event,
-) (error) {
-	// decode
-	family := opcode & 0xF000          // the highest 4 bits of the opcode
-	nnn := opcode & 0x0FFF             // addr
-	n := uint8(opcode & 0x000F)        // nibble
-	x := uint8((opcode & 0x0F00) >> 8) // x operand
-	y := uint8((opcode & 0x00F0) >> 4) // y operand
-	kk := uint8(opcode & 0x00FF)       // byte
+// execute opcode through the dispatch table
+func (c *cpu) exec(opcode uint16) error {
+	in := dispatch[opcode]
+	addr := c.pc - 2 // the address in memory whence the opcode was fetched
+	if c.prof != nil {
+		c.prof.exec(addr, in)
+	}
+	if err := in.exec(c, opcode); err != nil {
+		return err
+	}
+	if c.prof != nil {
+		c.prof.executed(in, opcode)
+	}
 
 	// debug
-	instruction := "" // generic name of instruction
-	cPseudo := ""     // c pseudo code
-	pc := c.pc - 2    // the address in memory whence the opcode was fetched
-
-	// execute instruction
-	switch family {
-	case 0x0000:
-		switch opcode {
-		case 0x00E0:
-			instruction = "00E0"
-			cPseudo = "clear()"
-			for i := 0; i < 32; i++ {
-				for j := 0; j < 64; j++ {
-					c.disp[i][j] = 0x00
-				}
-			}
-		case 0x00EE:
-			instruction = "00EE"
-			cPseudo = "return"
-			c.sp -= 1
-			c.pc = c.stack[c.sp]
-			c.stack[c.sp] = 0x00
-		default:
-			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
-			return errors.New(msg)
-		}
-	case 0x1000:
-		instruction = "1NNN"
-		cPseudo = "jump"
-		c.pc = nnn
-	case 0x2000:
-		instruction = "2NNN"
-		cPseudo = "function call"
-		c.stack[c.sp] = c.pc
-		c.sp += 1
-		c.pc = nnn
-	case 0x3000:
-		instruction = "3XKK"
-		cPseudo = "if v[x] == kk: continue"
-		if c.v[x] == kk {
-			c.pc += 2
-		}
-	case 0x4000:
-		instruction = "4XKK"
-		cPseudo = "if v[x] != kk: continue"
-		if c.v[x] != kk {
-			c.pc += 2
-		}
-	case 0x5000:
-		switch n {
-		case 0x0:
-			instruction = "5XY0"
-			cPseudo = "if v[x] == v[y]: continue"
-			if c.v[x] == c.v[y] {
-				c.pc += 2
-			}
-		default:
-			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
-			return errors.New(msg)
-		}
-	case 0x6000:
-		instruction = "6XKK"
-		cPseudo = "v[x] = kk"
-		c.v[x] = kk
-	case 0x7000:
-		instruction = "7XKK"
-		cPseudo = "v[x] = v[x] + kk"
-		c.v[x] = c.v[x] + kk
-	case 0x8000:
-		switch n {
-		case 0x0:
-			instruction = "8XY0"
-			cPseudo = "v[x] = v[y]"
-			c.v[x] = c.v[y]
-		case 0x1:
-			instruction = "8XY1"
-			cPseudo = "v[x] = v[x] | v[y]"
-			c.v[x] = (c.v[x] | c.v[y])
-		case 0x2:
-			instruction = "8XY2"
-			cPseudo = "v[x] = v[x] & v[y]"
-			c.v[x] = (c.v[x] & c.v[y])
-		case 0x3:
-			instruction = "8XY3"
-			cPseudo = "v[x] = v[x] ^ v[y]"
-			c.v[x] = (c.v[x] ^ c.v[y])
-		case 0x4:
-			instruction = "8XY4"
-			cPseudo = "if v[x] + v[y] > 0xFF: v[F] = 1 else: v[F] = 0; v[x] = v[x] + v[y]"
-			if uint16(c.v[x])+uint16(c.v[y]) > 0xFF {
-				c.v[0xF] = 0x01
-			} else {
-				c.v[0xF] = 0x00
-			}
-			c.v[x] += c.v[y]
-		case 0x5:
-			instruction = "8XY5"
-			cPseudo = "if v[x] > v[y]: v[F] = 1 else: v[F] = 0; v[x] = v[x] - v[y]"
-			if c.v[x] > c.v[y] {
-				c.v[0xF] = 0x01
-			} else {
-				c.v[0xF] = 0x00
-			}
-			c.v[x] -= c.v[y]
-		case 0x6:
-			instruction = "8XY6"
-			cPseudo = "if v[x] & 0x01: v[F] = 1 else: v[F] = 0; v[x] = v[x] / 2"
-			if c.v[x]&0x01 == 0x01 {
-				c.v[0xF] = 1
-			} else {
-				c.v[0xF] = 0
-			}
-			c.v[x] = c.v[x] / 2
-		case 0x7:
-			instruction = "8XY7"
-			cPseudo = "if v[y] > v[x]: v[F] = 1 else: v[F] = 0; v[x] = v[y] - v[x]"
-			if c.v[y] > c.v[x] {
-				c.v[0xF] = 0x01
-			} else {
-				c.v[0xF] = 0x00
-			}
-			c.v[x] = c.v[y] - c.v[x]
-		case 0xE:
-			instruction = "8XYE"
-			cPseudo = "if v[x] >> 7 == 1: v[F] = 1 else: v[F] = 0; v[x] = v[x] * 2"
-			if (c.v[x] >> 7) == 0x01 {
-				c.v[0xF] = 0x01
-			} else {
-				c.v[0xF] = 0x00
-			}
-			c.v[x] = c.v[x] * 2
-		default:
-			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
-			return errors.New(msg)
-		}
-	case 0x9000:
-		switch n {
-		case 0x00:
-			instruction = "9XY0"
-			cPseudo = "if v[x] != v[y]: pc = pc + 2"
-			if c.v[x] != c.v[y] {
-				c.pc += 2
-			}
-		default:
-			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
-			return errors.New(msg)
-		}
-	case 0xA000:
-		instruction = "ANNN"
-		cPseudo = "i = nnn"
-		c.i = nnn
-	case 0xB000:
-		instruction = "BNNN"
-		cPseudo = "pc = v[0] + nnn"
-		c.pc = uint16(c.v[0x0]) + nnn
-	case 0xC000: // TODO: unit test
-		instruction = "CNNN"
-		cPseudo = "v[x] = rand-byte & kk"
-		c.v[x] = uint8(rand.Uint32()) & kk
-	case 0xD000:
-		instruction = "DXYN"
-		cPseudo = "/* write n-rows of sprite to disp */"
-
-		// assume no pixels will be erased
-		c.v[0xF] = 0x00
-
-		// update display only when exec returns
-		defer flushPlugin()
-
-		// iterate through sprite rows
-		var rows uint8
-		for rows = 0; rows < n; rows++ {
-			// iterate through bits of sprite
-			var cols uint8
-			for cols = 0; cols < 8; cols++ {
-				// handle x wrap
-				dispX := c.v[x] + cols
-				if dispX >= 64 {
-					dispX -= 64
-				}
-
-				// handle y wrap
-				dispY := c.v[y] + rows
-				if dispY >= 32 {
-					dispY -= 32
-				}
+	if c.trace {
+		log.Printf(
+			"opcode: 0x%X, instruction: %s, cPseudo: %s, memaddr: 0x%X",
+			opcode,
+			in.name,
+			in.cPseudo,
+			addr,
+		)
+	}
 
-				// was the pixel on?
-				pixelWasOn := c.disp[dispY][dispX] > 0
-
-				// write to display
-				// how?
-				// get the sprite row from memory
-				// bit shift it to the left for the correct pixel
-				// mask it with 0x80 to get only the leftmost bit
-				// shift that bit all the way back to the right to get a 1 or 0
-				pixel := ((uint8(c.mem[c.i+uint16(rows)]) << cols) & 0x80) >> 0x07
-				c.disp[dispY][dispX] = c.disp[dispY][dispX] ^ pixel
-				if c.disp[dispY][dispX] == 1 {
-					c.draw(dispX, dispY, '█', drawPlugin)
-				} else {
-					c.draw(dispX, dispY, ' ', drawPlugin)
-				}
+	return nil
+}
 
-				// is the pixel now off?
-				pixelNowOff := c.disp[dispY][dispX] == 0
+// SDL video and events must stay on the main thread
+func init() {
+	runtime.LockOSThread()
+}
 
-				// flag VF if any pixels were erased
-				if pixelWasOn && pixelNowOff {
-					c.v[0xF] = 0x01
-				}
+// subcommands by name, anything else plays a rom
+var subcommands = map[string]func(args []string) error{
+	"bench":   benchCmd,
+	"batch":   batchCmd,
+	"compat":  compatCmd,
+	"profile": profileCmd,
+	"disasm":  disasmCmd,
+	"graph":   graphCmd,
+	"sprites": spritesCmd,
+	"cart":    cartCmd,
+	"browse":  browseCmd,
+	"patch":   patchCmd,
+	"gym":     gymCmd,
+}
 
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if cmd, ok := subcommands[args[0]]; ok {
+			if err := cmd(args[1:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
 			}
+			return
 		}
-	case 0xE000:
-		switch kk {
-		case 0x9E:
-			instruction = "EX9E"
-			cPseudo = "if keys[v[x]] == DOWN: pc += 2"
-			keyIsDown := c.keys[int(c.v[x])] == 1
-			if keyIsDown {
-				c.pc += 2
-			}
-		case 0xA1:
-			instruction = "EXA1"
-			cPseudo = "if keys[v[x]] == UP: pc += 2"
-			keyIsUp := c.keys[int(c.v[x])] == 0
-			if keyIsUp {
-				c.pc += 2
-			}
-		default:
-			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
-			return errors.New(msg)
-		}
-	case 0xF000:
-		switch kk {
-		case 0x07:
-			instruction = "FX07"
-			cPseudo = "v[x] = dt"
-			c.v[x] = c.dt
-		case 0x0A:
-			instruction = "FX0A"
-			cPseudo = "v[x] = getKey()"
-			c.v[x] = getKey(pollEventPlugin)
-		case 0x15:
-			instruction = "FX15"
-			cPseudo = "dt = v[x]"
-			c.dt = c.v[x]
-		case 0x18:
-			instruction = "FX18"
-			cPseudo = "st = v[x]"
-			c.st = c.v[x]
-		case 0x1E:
-			instruction = "FX1E"
-			cPseudo = "i += v[x]"
-			c.i += uint16(c.v[x])
-		case 0x29:
-			instruction = "FX29"
-			cPseudo = "i = &SPRITE(v[x])"
-			c.i = uint16(5 * c.v[x])
-		case 0x33:
-			instruction = "FX33"
-			cPseudo = "mem[i], mem[i+1], mem[i+2] = BCD(v[x])"
-			c.mem[c.i] = c.v[x] / 100
-			c.mem[c.i+1] = (c.v[x] % 100) / 10
-			c.mem[c.i+2] = ((c.v[x] % 100) % 10) / 1
-		case 0x55:
-			instruction = "FX55"
-			cPseudo = "mem[i:i+x] = v[0:x]"
-			var j uint8
-			for j = 0; j <= x; j++ {
-				c.mem[c.i+uint16(j)] = c.v[j]
-			}
-		case 0x65:
-			instruction = "FX65"
-			cPseudo = "v[0:x] = mem[i:i+x]"
-			var j uint8
-			for j = 0; j <= x; j++ {
-				c.v[j] = c.mem[c.i+uint16(j)]
-			}
+		if args[0] == "run" {
+			args = args[1:]
 		}
 	}
+	play(args)
+}
 
-	log.Printf(
-		"opcode: 0x%X, instruction: %s, cPseudo: %s, memaddr: 0x%X",
-		opcode,
-		instruction,
-		cPseudo,
-		pc,
-	)
-
-	return nil
+// switch to a speed, or back to normal if already there
+func toggleSpeed(current, speed float64) float64 {
+	if current == speed {
+		return 1
+	}
+	return speed
 }
 
-func main() {
+// run a rom interactively, pong by default
+func play(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	ipf := fs.Int("ipf", defaultIPF, "instructions per 60Hz frame")
+	audioBackend := fs.String("audio", "sdl", "audio backend: sdl, wav or null")
+	wavPath := fs.String("wav", "chip8.wav", "output file for the wav audio backend")
+	freq := fs.Float64("freq", 440, "beeper frequency in Hz")
+	volume := fs.Float64("volume", 0.25, "beeper volume from 0 to 1")
+	wave := fs.String("wave", "square", "beeper waveform: square, triangle, sawtooth or sine")
+	videoBackend := fs.String("video", "termbox", "video backend: termbox or sdl")
+	scale := fs.Int("scale", 10, "sdl window pixels per chip8 pixel")
+	paletteName := fs.String("palette", "green", "colours: "+strings.Join(paletteNames(), ", ")+" or RRGGBB:RRGGBB")
+	vsync := fs.Bool("vsync", true, "sync sdl presentation to the display refresh")
+	fullscreen := fs.Bool("fullscreen", false, "sdl fullscreen at the desktop resolution")
+	termModeName := fs.String("term", "auto", "terminal pixels per cell: full, half, braille or auto to fit")
+	colours := fs.String("colours", "auto", "terminal colours: 8, 256, truecolor or auto")
+	filterName := fs.String("filter", "none", "anti-flicker filter: none, blend or decay")
+	blendFrames := fs.Int("blend", 2, "frames ORed together by the blend filter")
+	decay := fs.Float64("decay", 0.5, "brightness kept per frame by the decay filter")
+	displayWait := fs.Bool("wait", false, "draw sprites only on 60Hz boundaries")
+	quirksName := fs.String("quirks", "chip8", "platform quirks: "+strings.Join(quirkPresetNames(), ", "))
+	layoutName := fs.String("layout", "chip8", "memory layout: "+strings.Join(layoutNames(), ", "))
+	loadAddr := fs.String("load", "", "program load address, overriding the layout")
+	entryAddr := fs.String("entry", "", "initial programme counter, the load address by default")
+	fontAddr := fs.String("font-addr", "", "hex font address, overriding the layout")
+	fontName := fs.String("font", "chip8", "hex font: "+strings.Join(fontNames(), ", ")+" or an 80 byte file")
+	romdbPath := fs.String("romdb", defaultROMDBPath(), "json rom database merged over the built in one")
+	fastForward := fs.Int("ff", 4, "frames per tick while fast-forwarding")
+	slowMotion := fs.Int("slow", 4, "ticks per frame in slow motion")
+	preset := fs.String("keys", "qwerty", "keymap preset: "+strings.Join(keymapPresetNames(), ", "))
+	keymapPath := fs.String("keymap", defaultKeymapPath(), "keymap json file")
+	cheatPath := fs.String("cheats", defaultCheatPath(), "json cheat file")
+	patchPath := fs.String("patch", "", "ips or bps patch applied to the rom as it loads")
+	codeMapPath := fs.String("codemap", "", "track code and data while playing, saved to this json file on exit")
+	fs.Parse(args)
+
+	// flags given on the command line win over the rom database
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	path := "pong.ch8"
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+
 	// set logging
 	logFile, err := os.OpenFile("ch8.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
@@ -544,39 +498,276 @@ func main() {
 	defer logFile.Close()
 	log.SetOutput(logFile)
 
+	// read rom into buffer
+	r, err := loadROM(path, promptROM)
+	if err != nil {
+		log.Printf("fatal rom error: %s", err)
+		os.Exit(1)
+	}
+	if *patchPath != "" {
+		patch, err := ioutil.ReadFile(*patchPath)
+		if err == nil {
+			r.data, err = applyPatch(r.data, patch)
+		}
+		if err != nil {
+			log.Printf("fatal patch error: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	if *fastForward < 1 || *slowMotion < 1 {
+		log.Printf("fatal speed error: ff and slow must be at least 1, got %d and %d", *fastForward, *slowMotion)
+		os.Exit(1)
+	}
+	if *volume < 0 || *volume > 1 {
+		log.Printf("fatal audio error: volume must be from 0 to 1, got %g", *volume)
+		os.Exit(1)
+	}
+
+	// per rom settings
+	q, err := parseQuirks(*quirksName)
+	if err != nil {
+		log.Printf("fatal quirks error: %s", err)
+		os.Exit(1)
+	}
+	l, err := parseLayout(*layoutName)
+	if err != nil {
+		log.Printf("fatal layout error: %s", err)
+		os.Exit(1)
+	}
+	opts := romOptions{quirks: q, layout: l, font: *fontName, ipf: *ipf, palette: *paletteName, filter: *filterName}
+	db, err := loadROMDB(*romdbPath)
+	if err != nil {
+		log.Printf("fatal romdb error: %s", err)
+		os.Exit(1)
+	}
+	program, entry, known, err := db.resolve(r, &opts, set)
+	if err != nil {
+		log.Printf("fatal rom error: %s", err)
+		os.Exit(1)
+	}
+	if known {
+		log.Printf("rom: %s (%s)", entry.program.Title, entry.hash)
+	}
+	if set["wait"] {
+		opts.quirks.wait = *displayWait
+	}
+	if err := opts.layout.override(*loadAddr, *entryAddr, *fontAddr); err != nil {
+		log.Printf("fatal layout error: %s", err)
+		os.Exit(1)
+	}
+	font, err := loadFont(opts.font)
+	if err != nil {
+		log.Printf("fatal font error: %s", err)
+		os.Exit(1)
+	}
+	if err := opts.layout.check(program, font); err != nil {
+		log.Printf("fatal rom error: %s", err)
+		os.Exit(1)
+	}
+
+	// input
+	km, err := loadKeymap(*preset, *keymapPath, r.name, romHash(program))
+	if err != nil {
+		log.Printf("fatal keymap error: %s", err)
+		os.Exit(1)
+	}
+	km.hint(entry.info.Keys)
+	ch, err := loadCheats(*cheatPath, r.name, romHash(program))
+	if err != nil {
+		log.Printf("fatal cheats error: %s", err)
+		os.Exit(1)
+	}
+
+	// init CHIP8, before the terminal is taken over
+	c := &cpu{
+		trace:  true,
+		quirks: opts.quirks,
+		layout: opts.layout,
+		font:   font,
+		cheats: ch,
+	}
+	if err := c.init(program); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal rom error: %s\n", err)
+		os.Exit(1)
+	}
+
 	// init SDL
 	err = sdl.Init(sdl.INIT_EVERYTHING)
 	if err != nil {
 		log.Printf("fatal SDL error: %s", err)
 	}
 
-	// raw calls to termbox
-	err = termbox.Init()
+	// sound
+	w, err := parseWaveform(*wave)
 	if err != nil {
-		log.Printf("fatal termbox error: %s", err)
+		fmt.Fprintf(os.Stderr, "fatal audio error: %s\n", err)
 		os.Exit(1)
 	}
-	defer termbox.Close()
+	t := newTone(*freq, *volume, w)
+	var a audio = nullAudio{}
+	switch *audioBackend {
+	case "sdl":
+		a, err = newSDLAudio(t)
+	case "wav":
+		a, err = newWAVAudio(*wavPath, t)
+	case "null":
+	default:
+		err = fmt.Errorf("unknown backend %q", *audioBackend)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal audio error: %s\n", err)
+		os.Exit(1)
+	}
+	defer a.close()
 
-	// read rom into buffer
-	program, _ := ioutil.ReadFile("pong.ch8")
+	// video
+	p, err := parsePalette(opts.palette)
+	if err != nil {
+		log.Printf("fatal video error: %s", err)
+		os.Exit(1)
+	}
+	f, err := newFilter(opts.filter, *blendFrames, *decay)
+	if err != nil {
+		log.Printf("fatal video error: %s", err)
+		os.Exit(1)
+	}
+	var v video
+	var out termbox.OutputMode
+	var panelX, panelY int // memory panel, beside the display if it fits
+	switch *videoBackend {
+	case "termbox":
+		mode, auto, err := parseTermMode(*termModeName)
+		if err != nil {
+			log.Printf("fatal video error: %s", err)
+			os.Exit(1)
+		}
+		out, err = parseOutputMode(*colours)
+		if err != nil {
+			log.Printf("fatal video error: %s", err)
+			os.Exit(1)
+		}
 
-	// init CHIP8
-	c := &cpu{}
-	c.init(program)
+		// raw calls to termbox
+		err = termbox.Init()
+		if err != nil {
+			log.Printf("fatal termbox error: %s", err)
+			os.Exit(1)
+		}
+		defer termbox.Close()
+		out = termbox.SetOutputMode(out)
+
+		if auto {
+			w, h := termbox.Size()
+			mode = autoTermMode(64, 32, w, h)
+		}
+		v = newTermVideo(mode, p, out)
+
+		w, h := termbox.Size()
+		cw, ch := mode.cells(64, 32)
+		panelX, panelY = cw+2, 0
+		if panelX+memViewW > w && ch+2+memViewH <= h {
+			panelX, panelY = 0, ch+2
+		}
+	case "sdl":
+		sv, err := newSDLVideo(*scale, p, *vsync, *fullscreen)
+		if err != nil {
+			log.Printf("fatal video error: %s", err)
+			os.Exit(1)
+		}
+		defer sv.close()
+		v = sv
+	default:
+		log.Printf("fatal video error: unknown backend %q", *videoBackend)
+		os.Exit(1)
+	}
+	v = newFilteredVideo(f, v)
+
+	// hand the machine its audio and video
+	c.audio, c.video = a, v
+	ch.patch(c)
+	var view *memView
+	if *videoBackend == "termbox" {
+		view = newMemView(c, panelX, panelY, out)
+		c.video = newMemVideo(view, c.video)
+	}
+	if *codeMapPath != "" {
+		c.prof = newProfile(c.layout.entry)
+		c.prof.selfModPlugin = func(pc, addr uint16) {
+			log.Printf("self-modifying write: 0x%03X overwrote code at 0x%03X", pc, addr)
+			c.announce(fmt.Sprintf("SELF-MOD 0x%03X -> 0x%03X", pc, addr))
+		}
+	}
 
 	// killswitch
 	kill := false
 
+	// emulator actions wait for the start of the next frame
+	var actions []action
+	var saved *state
+	queue := func(a action) { actions = append(actions, a) }
+	pending := func() bool { return len(actions) > 0 }
+	handle := func() {
+		for _, a := range actions {
+			switch a {
+			case actionQuit:
+				kill = true
+			case actionPause:
+				c.paused = !c.paused
+			case actionFrameAdvance:
+				// the first press stops, each one after runs a frame
+				c.advance = c.paused
+				c.paused = true
+			case actionFastForward:
+				c.speed = toggleSpeed(c.speed, float64(*fastForward))
+			case actionSlowMotion:
+				c.speed = toggleSpeed(c.speed, 1/float64(*slowMotion))
+			case actionMemory:
+				if view != nil {
+					view.open = !view.open
+				}
+			case actionReset:
+				if err := c.reset(program); err != nil {
+					log.Print(err)
+					kill = true
+				}
+				ch.patch(c)
+			case actionSaveState:
+				s := c.snapshot()
+				saved = &s
+			case actionLoadState:
+				if saved != nil {
+					c.restore(*saved)
+				}
+			}
+		}
+		actions = actions[:0]
+	}
+	var in *termInput
+	if *videoBackend == "termbox" {
+		in = newTermInput()
+		c.waitKeyPlugin = in.waitKey(km, pending, queue)
+	} else {
+		c.waitKeyPlugin = sdlWaitKey(km, pending, queue)
+	}
+
 	// play ^.^
-	go c.cycle(
-		termbox.SetCell,
-		termbox.Flush,
+	c.cycle(
+		opts.ipf,
+		func() {
+			pumpKeys(km, c.keys[:], queue)
+			if in != nil {
+				in.pump(km, view, queue)
+			}
+			handle()
+		},
 		time.Sleep,
-		termbox.PollEvent,
 		&kill,
 	)
 
-	// sdl
-	pollKeys(c.keys[:], &kill)
+	if c.prof != nil {
+		if err := saveCodeMap(*codeMapPath, c.prof.codeMap(program, c.layout.load)); err != nil {
+			log.Printf("fatal codemap error: %s", err)
+		}
+	}
 }
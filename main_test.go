@@ -1,7 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/nsf/termbox-go"
 )
 
 func mockAllOnDisplay() [32][64]uint8 {
@@ -1118,3 +1128,408 @@ func TestExec(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkExec(b *testing.B) {
+	program, err := ioutil.ReadFile("pong.ch8")
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := &cpu{}
+	c.init(program)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := c.exec(c.fetch()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// the nested switch exec the dispatch table replaced, as it was, kept to
+// benchmark against. it decoded and named every opcode as it ran, drew
+// each pixel through the terminal and logged every step. only getKey's
+// terminal polling is swapped for a plugin.
+func switchExec(
+	c *cpu,
+	opcode uint16,
+	drawPlugin func(x, y int, c rune, fg, bg termbox.Attribute),
+	flushPlugin func() error,
+	waitKeyPlugin func() uint8,
+) error {
+	// decode
+	family := opcode & 0xF000          // the highest 4 bits of the opcode
+	nnn := opcode & 0x0FFF             // addr
+	n := uint8(opcode & 0x000F)        // nibble
+	x := uint8((opcode & 0x0F00) >> 8) // x operand
+	y := uint8((opcode & 0x00F0) >> 4) // y operand
+	kk := uint8(opcode & 0x00FF)       // byte
+
+	// debug
+	instruction := "" // generic name of instruction
+	cPseudo := ""     // c pseudo code
+	pc := c.pc - 2    // the address in memory whence the opcode was fetched
+
+	// execute instruction
+	switch family {
+	case 0x0000:
+		switch opcode {
+		case 0x00E0:
+			instruction = "00E0"
+			cPseudo = "clear()"
+			for i := 0; i < 32; i++ {
+				for j := 0; j < 64; j++ {
+					c.disp[i][j] = 0x00
+				}
+			}
+		case 0x00EE:
+			instruction = "00EE"
+			cPseudo = "return"
+			c.sp -= 1
+			c.pc = c.stack[c.sp]
+			c.stack[c.sp] = 0x00
+		default:
+			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
+			return errors.New(msg)
+		}
+	case 0x1000:
+		instruction = "1NNN"
+		cPseudo = "jump"
+		c.pc = nnn
+	case 0x2000:
+		instruction = "2NNN"
+		cPseudo = "function call"
+		c.stack[c.sp] = c.pc
+		c.sp += 1
+		c.pc = nnn
+	case 0x3000:
+		instruction = "3XKK"
+		cPseudo = "if v[x] == kk: continue"
+		if c.v[x] == kk {
+			c.pc += 2
+		}
+	case 0x4000:
+		instruction = "4XKK"
+		cPseudo = "if v[x] != kk: continue"
+		if c.v[x] != kk {
+			c.pc += 2
+		}
+	case 0x5000:
+		switch n {
+		case 0x0:
+			instruction = "5XY0"
+			cPseudo = "if v[x] == v[y]: continue"
+			if c.v[x] == c.v[y] {
+				c.pc += 2
+			}
+		default:
+			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
+			return errors.New(msg)
+		}
+	case 0x6000:
+		instruction = "6XKK"
+		cPseudo = "v[x] = kk"
+		c.v[x] = kk
+	case 0x7000:
+		instruction = "7XKK"
+		cPseudo = "v[x] = v[x] + kk"
+		c.v[x] = c.v[x] + kk
+	case 0x8000:
+		switch n {
+		case 0x0:
+			instruction = "8XY0"
+			cPseudo = "v[x] = v[y]"
+			c.v[x] = c.v[y]
+		case 0x1:
+			instruction = "8XY1"
+			cPseudo = "v[x] = v[x] | v[y]"
+			c.v[x] = (c.v[x] | c.v[y])
+		case 0x2:
+			instruction = "8XY2"
+			cPseudo = "v[x] = v[x] & v[y]"
+			c.v[x] = (c.v[x] & c.v[y])
+		case 0x3:
+			instruction = "8XY3"
+			cPseudo = "v[x] = v[x] ^ v[y]"
+			c.v[x] = (c.v[x] ^ c.v[y])
+		case 0x4:
+			instruction = "8XY4"
+			cPseudo = "if v[x] + v[y] > 0xFF: v[F] = 1 else: v[F] = 0; v[x] = v[x] + v[y]"
+			if uint16(c.v[x])+uint16(c.v[y]) > 0xFF {
+				c.v[0xF] = 0x01
+			} else {
+				c.v[0xF] = 0x00
+			}
+			c.v[x] += c.v[y]
+		case 0x5:
+			instruction = "8XY5"
+			cPseudo = "if v[x] > v[y]: v[F] = 1 else: v[F] = 0; v[x] = v[x] - v[y]"
+			if c.v[x] > c.v[y] {
+				c.v[0xF] = 0x01
+			} else {
+				c.v[0xF] = 0x00
+			}
+			c.v[x] -= c.v[y]
+		case 0x6:
+			instruction = "8XY6"
+			cPseudo = "if v[x] & 0x01: v[F] = 1 else: v[F] = 0; v[x] = v[x] / 2"
+			if c.v[x]&0x01 == 0x01 {
+				c.v[0xF] = 1
+			} else {
+				c.v[0xF] = 0
+			}
+			c.v[x] = c.v[x] / 2
+		case 0x7:
+			instruction = "8XY7"
+			cPseudo = "if v[y] > v[x]: v[F] = 1 else: v[F] = 0; v[x] = v[y] - v[x]"
+			if c.v[y] > c.v[x] {
+				c.v[0xF] = 0x01
+			} else {
+				c.v[0xF] = 0x00
+			}
+			c.v[x] = c.v[y] - c.v[x]
+		case 0xE:
+			instruction = "8XYE"
+			cPseudo = "if v[x] >> 7 == 1: v[F] = 1 else: v[F] = 0; v[x] = v[x] * 2"
+			if (c.v[x] >> 7) == 0x01 {
+				c.v[0xF] = 0x01
+			} else {
+				c.v[0xF] = 0x00
+			}
+			c.v[x] = c.v[x] * 2
+		default:
+			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
+			return errors.New(msg)
+		}
+	case 0x9000:
+		switch n {
+		case 0x00:
+			instruction = "9XY0"
+			cPseudo = "if v[x] != v[y]: pc = pc + 2"
+			if c.v[x] != c.v[y] {
+				c.pc += 2
+			}
+		default:
+			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
+			return errors.New(msg)
+		}
+	case 0xA000:
+		instruction = "ANNN"
+		cPseudo = "i = nnn"
+		c.i = nnn
+	case 0xB000:
+		instruction = "BNNN"
+		cPseudo = "pc = v[0] + nnn"
+		c.pc = uint16(c.v[0x0]) + nnn
+	case 0xC000: // TODO: unit test
+		instruction = "CNNN"
+		cPseudo = "v[x] = rand-byte & kk"
+		c.v[x] = uint8(rand.Uint32()) & kk
+	case 0xD000:
+		instruction = "DXYN"
+		cPseudo = "/* write n-rows of sprite to disp */"
+
+		// assume no pixels will be erased
+		c.v[0xF] = 0x00
+
+		// update display only when exec returns
+		defer flushPlugin()
+
+		// iterate through sprite rows
+		var rows uint8
+		for rows = 0; rows < n; rows++ {
+			// iterate through bits of sprite
+			var cols uint8
+			for cols = 0; cols < 8; cols++ {
+				// handle x wrap
+				dispX := c.v[x] + cols
+				if dispX >= 64 {
+					dispX -= 64
+				}
+
+				// handle y wrap
+				dispY := c.v[y] + rows
+				if dispY >= 32 {
+					dispY -= 32
+				}
+
+				// was the pixel on?
+				pixelWasOn := c.disp[dispY][dispX] > 0
+
+				// write to display
+				// how?
+				// get the sprite row from memory
+				// bit shift it to the left for the correct pixel
+				// mask it with 0x80 to get only the leftmost bit
+				// shift that bit all the way back to the right to get a 1 or 0
+				pixel := ((uint8(c.mem[c.i+uint16(rows)]) << cols) & 0x80) >> 0x07
+				c.disp[dispY][dispX] = c.disp[dispY][dispX] ^ pixel
+				if c.disp[dispY][dispX] == 1 {
+					switchDraw(dispX, dispY, '█', drawPlugin)
+				} else {
+					switchDraw(dispX, dispY, ' ', drawPlugin)
+				}
+
+				// is the pixel now off?
+				pixelNowOff := c.disp[dispY][dispX] == 0
+
+				// flag VF if any pixels were erased
+				if pixelWasOn && pixelNowOff {
+					c.v[0xF] = 0x01
+				}
+
+			}
+		}
+	case 0xE000:
+		switch kk {
+		case 0x9E:
+			instruction = "EX9E"
+			cPseudo = "if keys[v[x]] == DOWN: pc += 2"
+			keyIsDown := c.keys[int(c.v[x])] == 1
+			if keyIsDown {
+				c.pc += 2
+			}
+		case 0xA1:
+			instruction = "EXA1"
+			cPseudo = "if keys[v[x]] == UP: pc += 2"
+			keyIsUp := c.keys[int(c.v[x])] == 0
+			if keyIsUp {
+				c.pc += 2
+			}
+		default:
+			msg := fmt.Sprintf("fatal error: unknown opcode 0x%X", opcode)
+			return errors.New(msg)
+		}
+	case 0xF000:
+		switch kk {
+		case 0x07:
+			instruction = "FX07"
+			cPseudo = "v[x] = dt"
+			c.v[x] = c.dt
+		case 0x0A:
+			instruction = "FX0A"
+			cPseudo = "v[x] = getKey()"
+			c.v[x] = waitKeyPlugin()
+		case 0x15:
+			instruction = "FX15"
+			cPseudo = "dt = v[x]"
+			c.dt = c.v[x]
+		case 0x18:
+			instruction = "FX18"
+			cPseudo = "st = v[x]"
+			c.st = c.v[x]
+		case 0x1E:
+			instruction = "FX1E"
+			cPseudo = "i += v[x]"
+			c.i += uint16(c.v[x])
+		case 0x29:
+			instruction = "FX29"
+			cPseudo = "i = &SPRITE(v[x])"
+			c.i = uint16(5 * c.v[x])
+		case 0x33:
+			instruction = "FX33"
+			cPseudo = "mem[i], mem[i+1], mem[i+2] = BCD(v[x])"
+			c.mem[c.i] = c.v[x] / 100
+			c.mem[c.i+1] = (c.v[x] % 100) / 10
+			c.mem[c.i+2] = ((c.v[x] % 100) % 10) / 1
+		case 0x55:
+			instruction = "FX55"
+			cPseudo = "mem[i:i+x] = v[0:x]"
+			var j uint8
+			for j = 0; j <= x; j++ {
+				c.mem[c.i+uint16(j)] = c.v[j]
+			}
+		case 0x65:
+			instruction = "FX65"
+			cPseudo = "v[0:x] = mem[i:i+x]"
+			var j uint8
+			for j = 0; j <= x; j++ {
+				c.v[j] = c.mem[c.i+uint16(j)]
+			}
+		}
+	}
+
+	log.Printf(
+		"opcode: 0x%X, instruction: %s, cPseudo: %s, memaddr: 0x%X",
+		opcode,
+		instruction,
+		cPseudo,
+		pc,
+	)
+
+	return nil
+}
+
+// the old cpu.draw, both runes it drew being one cell wide
+func switchDraw(x, y uint8, r rune, f func(x, y int, c rune, fg, bg termbox.Attribute)) {
+	wideX := int(x * 2)
+	wideY := int(y)
+	f(wideX, wideY, r, termbox.ColorGreen, termbox.ColorDefault)
+	f(wideX+1, wideY, r, termbox.ColorGreen, termbox.ColorDefault)
+}
+
+func TestExecTrace(t *testing.T) {
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	defer log.SetOutput(os.Stderr)
+
+	// a jump logs where it was fetched from, not where it went
+	c := &cpu{trace: true}
+	c.init([]byte{0x12, 0x08})
+	if err := c.exec(c.fetch()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "memaddr: 0x200") {
+		t.Fatalf("fatal trace error: expected memaddr 0x200, got %q", out.String())
+	}
+}
+
+func BenchmarkSwitchExec(b *testing.B) {
+	program, err := ioutil.ReadFile("pong.ch8")
+	if err != nil {
+		b.Fatal(err)
+	}
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	c := &cpu{}
+	c.init(program)
+	b.ResetTimer()
+	draw := func(x, y int, r rune, fg, bg termbox.Attribute) {}
+	flush := func() error { return nil }
+	waitKey := func() uint8 { return 0 }
+	for n := 0; n < b.N; n++ {
+		if err := switchExec(c, c.fetch(), draw, flush, waitKey); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTrapIOutOfRange(t *testing.T) {
+	cases := []struct {
+		desc    string
+		program []byte
+		i       uint16 // where it traps, 0 if it runs on
+	}{
+		{"store", []byte{0xAF, 0xFF, 0xF2, 0x55}, 0xFFF},
+		{"load", []byte{0xAF, 0xFE, 0xF1, 0x65}, 0},
+		{"load past", []byte{0xAF, 0xFF, 0x60, 0x02, 0xF0, 0x1E, 0xF0, 0x65}, 0x1001},
+		{"bcd", []byte{0xAF, 0xFE, 0xF0, 0x33}, 0xFFE},
+		{"bcd fits", []byte{0xAF, 0xFD, 0xF0, 0x33}, 0},
+		{"draw", []byte{0xAF, 0xFC, 0xD0, 0x05}, 0xFFC},
+	}
+	for _, tc := range cases {
+		c := &cpu{}
+		end := 0x200 + len(tc.program)
+		if err := c.init(append(tc.program, uint8(0x10|end>>8), uint8(end))); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.run(10)
+		if tc.i == 0 {
+			if err != nil {
+				t.Fatalf("fatal trap error for %s: expected no trap, got %v", tc.desc, err)
+			}
+			continue
+		}
+		if tr, ok := err.(*trap); !ok || tr.kind != "i out of range" || tr.opcode != tc.i {
+			t.Fatalf("fatal trap error for %s: expected i out of range at 0x%X, got %v", tc.desc, tc.i, err)
+		}
+	}
+}
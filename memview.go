@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nsf/termbox-go"
+)
+
+// panel size in character cells
+const (
+	memViewW    = 50
+	memViewH    = 21
+	memViewRows = 16 // memory rows shown, 8 bytes each
+)
+
+// frames a write stays highlighted
+const memViewFlash = 30
+
+// memory and registers beside the display, editable while paused
+type memView struct {
+	c      *cpu
+	open   bool
+	x, y   int         // top left cell of the panel
+	regs   bool        // editing registers rather than memory
+	cursor uint16      // selected byte
+	reg    int         // selected register, in memViewRegs order
+	top    uint16      // address of the first row shown
+	prev   [4096]uint8 // memory at the last update
+	flash  [4096]uint8 // frames left to highlight each recent write
+	shown  bool        // was the panel drawn last frame?
+
+	// highlights
+	pcFg, iFg, stackFg, writeFg termbox.Attribute
+}
+
+func newMemView(c *cpu, x, y int, out termbox.OutputMode) *memView {
+	v := &memView{
+		c:       c,
+		x:       x,
+		y:       y,
+		prev:    c.mem,
+		pcFg:    termColour(0x33FF33, out),
+		iFg:     termColour(0x33CCFF, out),
+		stackFg: termColour(0xFFB000, out),
+		writeFg: termColour(0xFF3333, out),
+	}
+	if out == termbox.OutputNormal {
+		v.pcFg, v.iFg, v.stackFg, v.writeFg = termbox.ColorGreen, termbox.ColorCyan, termbox.ColorYellow, termbox.ColorRed
+	}
+	return v
+}
+
+// editable registers, in the order the cursor moves through them
+var memViewRegs = []string{
+	"v0", "v1", "v2", "v3", "v4", "v5", "v6", "v7",
+	"v8", "v9", "vA", "vB", "vC", "vD", "vE", "vF",
+	"i", "pc", "dt", "st",
+}
+
+func (c *cpu) register(k int) uint16 {
+	switch {
+	case k < 16:
+		return uint16(c.v[k])
+	case k == 16:
+		return c.i
+	case k == 17:
+		return c.pc
+	case k == 18:
+		return uint16(c.dt)
+	}
+	return uint16(c.st)
+}
+
+func (c *cpu) setRegister(k int, value uint16) {
+	switch {
+	case k < 16:
+		c.v[k] = uint8(value)
+	case k == 16:
+		c.i = value & 0xFFF
+	case k == 17:
+		c.pc = value & 0xFFF
+	case k == 18:
+		c.dt = uint8(value)
+	default:
+		c.st = uint8(value)
+	}
+}
+
+// note writes since the last frame, and follow pc while running
+func (v *memView) update() {
+	for a := range v.c.mem {
+		if v.c.mem[a] != v.prev[a] {
+			v.flash[a] = memViewFlash
+		} else if v.flash[a] > 0 {
+			v.flash[a]--
+		}
+	}
+	v.prev = v.c.mem
+	if !v.c.paused {
+		v.cursor = v.c.pc
+	}
+	v.scroll()
+}
+
+// keep the cursor on screen, centring it when it goes off
+func (v *memView) scroll() {
+	row := v.cursor &^ 7
+	if row < v.top || row >= v.top+8*memViewRows {
+		v.top = 0
+		if row > 8*memViewRows/2 {
+			v.top = row - 8*memViewRows/2
+		}
+	}
+	if v.top > 0x1000-8*memViewRows {
+		v.top = 0x1000 - 8*memViewRows
+	}
+}
+
+// handle a key typed while the panel is open, false if it is not
+// the panel's. keys only edit while paused:
+//
+//	arrows   move the cursor
+//	0-9, a-f shift a hex digit into the byte or register
+//	r        switch between memory and registers
+//	p, i     move the cursor to pc or i
+//	z        freeze the selected byte or register, or let it go
+//	s        start a memory search
+//	= ! + -  keep candidates equal, changed, increased or decreased
+//	n        move the cursor to the next candidate
+func (v *memView) key(name string) bool {
+	if !v.open || !v.c.paused {
+		return false
+	}
+	if len(name) == 1 {
+		if d, ok := hexDigit(name[0]); ok {
+			v.edit(d)
+			return true
+		}
+	}
+	step := map[string]int{"left": -1, "right": 1, "up": -8, "down": 8}
+	switch name {
+	case "left", "right", "up", "down":
+		if v.regs {
+			v.reg = (v.reg + step[name] + len(memViewRegs)) % len(memViewRegs)
+		} else {
+			v.cursor = uint16(int(v.cursor)+step[name]) & 0xFFF
+		}
+	case "r":
+		v.regs = !v.regs
+	case "p":
+		v.regs, v.cursor = false, v.c.pc
+	case "i":
+		v.regs, v.cursor = false, v.c.i&0xFFF
+	case "z", "s", "=", "!", "+", "-", "n":
+		return v.cheat(name)
+	default:
+		return false
+	}
+	v.scroll()
+	return true
+}
+
+// cheat keys, ignored when the machine runs without cheats
+func (v *memView) cheat(name string) bool {
+	ch := v.c.cheats
+	if ch == nil {
+		return false
+	}
+	compare := map[string]string{"=": "equal", "!": "changed", "+": "increased", "-": "decreased"}
+	switch name {
+	case "z":
+		switch {
+		case !v.regs:
+			ch.toggle(v.c, cheatTarget{addr: v.cursor})
+		case v.reg < 16:
+			ch.toggle(v.c, cheatTarget{reg: true, addr: uint16(v.reg)})
+		}
+	case "s":
+		ch.search = newRAMSearch(v.c)
+	case "n":
+		if ch.search != nil {
+			if a, ok := ch.search.next(v.cursor); ok {
+				v.regs, v.cursor = false, a
+				v.scroll()
+			}
+		}
+	default:
+		if ch.search != nil {
+			ch.search.narrow(v.c, compare[name])
+		}
+	}
+	return true
+}
+
+func hexDigit(b byte) (uint16, bool) {
+	switch {
+	case b >= '0' && b <= '9':
+		return uint16(b - '0'), true
+	case b >= 'a' && b <= 'f':
+		return uint16(b-'a') + 10, true
+	}
+	return 0, false
+}
+
+// shift a hex digit in from the right, so two digits set a byte
+func (v *memView) edit(d uint16) {
+	if v.regs {
+		v.c.setRegister(v.reg, v.c.register(v.reg)<<4|d)
+		return
+	}
+	a := v.cursor
+	v.c.mem[a] = v.c.mem[a]<<4 | uint8(d)
+
+	// edits are not the program's writes
+	v.prev[a] = v.c.mem[a]
+	if v.c.jit != nil {
+		v.c.jit = newRecompiler()
+	}
+}
+
+// colours for a memory byte, most telling first
+func (v *memView) byteColours(a uint16, stack map[uint16]bool) (fg, bg termbox.Attribute) {
+	fg, bg = termbox.ColorDefault, termbox.ColorDefault
+	switch {
+	case a == v.c.pc || a == v.c.pc+1:
+		fg = v.pcFg
+	case a == v.c.i&0xFFF:
+		fg = v.iFg
+	case stack[a] || stack[a-1]:
+		fg = v.stackFg
+	case v.flash[a] > 0:
+		fg = v.writeFg
+	}
+	if ch := v.c.cheats; ch != nil {
+		if _, ok := ch.frozen[cheatTarget{addr: a}]; ok {
+			fg |= termbox.AttrBold
+		}
+		if ch.search != nil && len(ch.search.candidates) <= 64 && ch.search.has(a) {
+			fg |= termbox.AttrUnderline
+		}
+	}
+	if !v.regs && a == v.cursor && v.c.paused {
+		fg |= termbox.AttrReverse
+	}
+	return fg, bg
+}
+
+// draw the panel, or blank it once after it closes
+func (v *memView) draw(setCell func(x, y int, r rune, fg, bg termbox.Attribute)) {
+	if !v.open {
+		if v.shown {
+			for y := 0; y < memViewH; y++ {
+				for x := 0; x < memViewW; x++ {
+					setCell(v.x+x, v.y+y, ' ', termbox.ColorDefault, termbox.ColorDefault)
+				}
+			}
+			v.shown = false
+		}
+		return
+	}
+	v.shown = true
+
+	// text at a cell, padding the row out to the panel's width
+	put := func(x, y int, s string, fg, bg termbox.Attribute) int {
+		for _, r := range s {
+			setCell(v.x+x, v.y+y, r, fg, bg)
+			x++
+		}
+		return x
+	}
+	line := func(y int, s string) {
+		x := put(0, y, s, termbox.ColorDefault, termbox.ColorDefault)
+		put(x, y, fmt.Sprintf("%*s", memViewW-x, ""), termbox.ColorDefault, termbox.ColorDefault)
+	}
+
+	// registers, the selected one reversed while editing them
+	regFg := func(k int) termbox.Attribute {
+		if v.regs && v.c.paused && k == v.reg {
+			return termbox.AttrReverse
+		}
+		return termbox.ColorDefault
+	}
+	line(0, "")
+	x := 0
+	for k := 16; k < len(memViewRegs); k++ {
+		format := "%02X"
+		if k == 16 || k == 17 {
+			format = "%03X"
+		}
+		x = put(x, 0, memViewRegs[k]+" ", termbox.ColorDefault, termbox.ColorDefault)
+		x = put(x, 0, fmt.Sprintf(format, v.c.register(k)), regFg(k), termbox.ColorDefault)
+		x = put(x, 0, "  ", termbox.ColorDefault, termbox.ColorDefault)
+	}
+	put(x, 0, fmt.Sprintf("sp %d", v.c.sp), termbox.ColorDefault, termbox.ColorDefault)
+	for row := 0; row < 2; row++ {
+		line(1+row, fmt.Sprintf("v%X-%X", 8*row, 8*row+7))
+		for k := 8 * row; k < 8*row+8; k++ {
+			put(5+3*(k-8*row), 1+row, fmt.Sprintf("%02X", v.c.v[k]), regFg(k), termbox.ColorDefault)
+		}
+	}
+
+	// return addresses, innermost last, as many as fit
+	stack := map[uint16]bool{}
+	text := ""
+	for k := 0; k < int(v.c.sp) && k < len(v.c.stack); k++ {
+		stack[v.c.stack[k]] = true
+		text += fmt.Sprintf(" %03X", v.c.stack[k])
+	}
+	if len(text) > 44 {
+		text = text[len(text)-44:]
+	}
+	line(3, "stack"+text)
+	line(4, fmt.Sprintf("%-40s at i", v.cheatStatus()))
+
+	// hex and ascii grid
+	for row := 0; row < memViewRows; row++ {
+		y := 5 + row
+		base := v.top + uint16(8*row)
+		line(y, fmt.Sprintf("%03X", base))
+		ascii := []rune("|........|")
+		for col := 0; col < 8; col++ {
+			a := base + uint16(col)
+			fg, bg := v.byteColours(a, stack)
+			put(5+3*col, y, fmt.Sprintf("%02X", v.c.mem[a]), fg, bg)
+			if b := v.c.mem[a]; b >= 0x20 && b < 0x7F {
+				ascii[1+col] = rune(b)
+			}
+		}
+		put(30, y, string(ascii), termbox.ColorDefault, termbox.ColorDefault)
+	}
+
+	// what a DXYN would draw from i
+	for k, row := range v.c.spriteAtI(8) {
+		put(41, 5+k, row, v.iFg, termbox.ColorDefault)
+	}
+}
+
+// frozen values and search progress
+func (v *memView) cheatStatus() string {
+	ch := v.c.cheats
+	if ch == nil {
+		return ""
+	}
+	text := fmt.Sprintf("frozen %d", len(ch.frozen))
+	if ch.search != nil {
+		text += fmt.Sprintf("  search %d left", len(ch.search.candidates))
+	}
+	return text
+}
+
+// draws the memory panel into the terminal with each frame
+type memVideo struct {
+	view *memView
+	next video
+
+	// plugins
+	setCellPlugin func(x, y int, r rune, fg, bg termbox.Attribute)
+}
+
+func newMemVideo(view *memView, next video) *memVideo {
+	return &memVideo{view: view, next: next, setCellPlugin: termbox.SetCell}
+}
+
+// the panel goes in first, the terminal video flushes it with the display
+func (v *memVideo) present(disp *[32][64]uint8, dirty uint32) error {
+	v.view.update()
+	v.view.draw(v.setCellPlugin)
+	return v.next.present(disp, dirty)
+}
+
+func (v *memVideo) status(text string) error {
+	if sv, ok := v.next.(statusVideo); ok {
+		return sv.status(text)
+	}
+	return nil
+}
+
+func (v *memVideo) close() error {
+	return v.next.close()
+}
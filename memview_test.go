@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestMemView(t *testing.T) {
+	c := &cpu{}
+	if err := c.init([]byte{0x00, 0xE0, 0x12, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	v := newMemView(c, 0, 0, termbox.OutputNormal)
+	v.open = true
+	if v.key("a") {
+		t.Fatalf("fatal memory view error: expected keys ignored while running")
+	}
+
+	// edit the byte at pc, then v1
+	c.paused = true
+	for _, name := range []string{"p", "a", "b", "r", "right", "1", "2"} {
+		if !v.key(name) {
+			t.Fatalf("fatal memory view error for %s: expected the key handled", name)
+		}
+	}
+	if c.mem[0x200] != 0xAB || c.v[1] != 0x12 {
+		t.Fatalf("fatal memory view error: expected mem[0x200] 0xAB and v1 0x12, got 0x%X and 0x%X", c.mem[0x200], c.v[1])
+	}
+	if v.key("space") {
+		t.Fatalf("fatal memory view error: expected actions passed on")
+	}
+
+	// i stays inside memory however many digits go in
+	v.reg = 16
+	for _, name := range []string{"1", "0", "0", "0"} {
+		v.key(name)
+	}
+	if c.i != 0x000 {
+		t.Fatalf("fatal memory view error: expected i 0x000 after typing 1000, got 0x%X", c.i)
+	}
+
+	// program writes flash, edits do not
+	c.mem[0x300] = 1
+	v.update()
+	if v.flash[0x300] != memViewFlash || v.flash[0x200] != 0 {
+		t.Fatalf("fatal memory view error: expected only 0x300 flashed")
+	}
+
+	cells := map[[2]int]rune{}
+	fgs := map[[2]int]termbox.Attribute{}
+	v.regs = false
+	v.draw(func(x, y int, r rune, fg, bg termbox.Attribute) {
+		cells[[2]int{x, y}], fgs[[2]int{x, y}] = r, fg
+	})
+	row := func(y int) string {
+		s := []rune{}
+		for x := 0; x < memViewW; x++ {
+			s = append(s, cells[[2]int{x, y}])
+		}
+		return string(s)
+	}
+	// pc is centred, i is still 0 and shows the font's zero
+	if row(13) != "200  AB E0 12 02 00 00 00 00  |........|          " {
+		t.Fatalf("fatal memory view error: unexpected pc row %q", row(13))
+	}
+	if row(5) != "1C0  00 00 00 00 00 00 00 00  |........| ####.... " {
+		t.Fatalf("fatal memory view error: unexpected first row %q", row(5))
+	}
+	if fgs[[2]int{5, 13}] != termbox.ColorGreen|termbox.AttrReverse || fgs[[2]int{11, 13}] != termbox.ColorDefault {
+		t.Fatalf("fatal memory view error: expected the cursor on pc highlighted")
+	}
+}
+
+func TestTermInput(t *testing.T) {
+	km, err := presetKeymap("qwerty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &termInput{events: make(chan termbox.Event, 4)}
+	in.events <- termbox.Event{Type: termbox.EventKey, Ch: 'q'}
+	in.events <- termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace}
+	in.events <- termbox.Event{Type: termbox.EventResize}
+
+	var actions []action
+	in.pump(km, nil, func(a action) { actions = append(actions, a) })
+	if len(actions) != 1 || actions[0] != actionPause {
+		t.Fatalf("fatal term input error: expected pause, got %v", actions)
+	}
+	k, ok := in.waitKey(km, func() bool { return false }, nil)()
+	if !ok || k != 0x4 {
+		t.Fatalf("fatal term input error: expected key 0x4 kept for the wait, got 0x%X", k)
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// an assembler for the chip8 part of octo: labels, :const, :alias, :org,
+// :unpack, :call, :byte, if/else/end and loop/while/again with octo's
+// comparison pseudo-ops, and every chip8 instruction. macros, :calc and
+// the schip and xo-chip instructions are refused by name.
+type octoAssembler struct {
+	toks    []octoToken
+	pos     int
+	out     []byte // from 0x200
+	here    int    // address of the next byte
+	labels  map[string]int
+	consts  map[string]int
+	aliases map[string]uint16
+	fixups  []octoFixup
+	flow    []octoFlow
+}
+
+type octoToken struct {
+	text string
+	line int
+}
+
+// an address used before its label, patched into the low twelve bits
+type octoFixup struct {
+	at   int
+	name string
+	line int
+}
+
+// an open if or loop
+type octoFlow struct {
+	kind   string // begin, else or loop
+	at     int    // the jump to patch, or where the loop starts
+	breaks []int  // jumps out of a loop, from while
+	line   int
+}
+
+type octoError struct{ err error }
+
+// names octo knows that this emulator does not run
+var octoUnsupported = map[string]bool{
+	"hires": true, "lores": true, "exit": true, "scroll-down": true, "scroll-up": true,
+	"scroll-left": true, "scroll-right": true, "saveflags": true, "loadflags": true,
+	"plane": true, "audio": true, "pitch": true, "bighex": true, "long": true,
+}
+
+// the program's bytes. octo puts a jump to main at 0x200 unless main
+// is already there, which keeps byte listings exactly as written.
+func assembleOcto(src string) ([]byte, error) {
+	program, main, err := octoPass(src, false)
+	if err == nil && main != 0x200 {
+		program, _, err = octoPass(src, true)
+	}
+	return program, err
+}
+
+func octoPass(src string, jumpMain bool) (program []byte, main int, err error) {
+	a := &octoAssembler{
+		here:    0x200,
+		labels:  map[string]int{},
+		consts:  map[string]int{},
+		aliases: map[string]uint16{},
+	}
+	for n, line := range strings.Split(src, "\n") {
+		if k := strings.IndexByte(line, '#'); k >= 0 {
+			line = line[:k]
+		}
+		for _, f := range strings.Fields(line) {
+			a.toks = append(a.toks, octoToken{f, n + 1})
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(octoError)
+			if !ok {
+				panic(r)
+			}
+			program, main, err = nil, 0, e.err
+		}
+	}()
+
+	if jumpMain {
+		a.fixups = append(a.fixups, octoFixup{a.here, "main", 1})
+		a.inst(0x1000)
+	}
+	for a.pos < len(a.toks) {
+		a.statement()
+	}
+	if len(a.flow) > 0 {
+		f := a.flow[len(a.flow)-1]
+		return nil, 0, fmt.Errorf("octo: line %d: %s never closed", f.line, f.kind)
+	}
+	main, ok := a.labels["main"]
+	if !ok {
+		return nil, 0, fmt.Errorf("octo: no main label")
+	}
+	for _, f := range a.fixups {
+		addr, ok := a.labels[f.name]
+		if !ok {
+			return nil, 0, fmt.Errorf("octo: line %d: undefined name %q", f.line, f.name)
+		}
+		a.patch(f.at, addr)
+	}
+	if len(a.out) == 0 {
+		return nil, 0, fmt.Errorf("octo: empty program")
+	}
+	return a.out, main, nil
+}
+
+func (a *octoAssembler) fail(format string, args ...interface{}) {
+	line := 0
+	if a.pos > 0 {
+		line = a.toks[a.pos-1].line
+	}
+	panic(octoError{fmt.Errorf("octo: line %d: "+format, append([]interface{}{line}, args...)...)})
+}
+
+func (a *octoAssembler) next() string {
+	if a.pos >= len(a.toks) {
+		a.fail("unexpected end of source")
+	}
+	a.pos++
+	return a.toks[a.pos-1].text
+}
+
+func (a *octoAssembler) peek() string {
+	if a.pos >= len(a.toks) {
+		return ""
+	}
+	return a.toks[a.pos].text
+}
+
+func (a *octoAssembler) expect(t string) {
+	if got := a.next(); got != t {
+		a.fail("expected %s, got %q", t, got)
+	}
+}
+
+func (a *octoAssembler) emit(bs ...byte) {
+	for _, b := range bs {
+		if a.here > 0xFFF {
+			a.fail("program past 0xFFF")
+		}
+		k := a.here - 0x200
+		for len(a.out) <= k {
+			a.out = append(a.out, 0)
+		}
+		a.out[k] = b
+		a.here++
+	}
+}
+
+func (a *octoAssembler) inst(op uint16) {
+	a.emit(byte(op>>8), byte(op))
+}
+
+// set the low twelve bits of the pair at at
+func (a *octoAssembler) patch(at, addr int) {
+	k := at - 0x200
+	a.out[k] = a.out[k]&0xF0 | byte(addr>>8)&0x0F
+	a.out[k+1] = byte(addr)
+}
+
+func (a *octoAssembler) statement() {
+	t := a.next()
+	switch t {
+	case ":":
+		name := a.next()
+		if _, ok := a.labels[name]; ok {
+			a.fail("%q defined twice", name)
+		}
+		a.labels[name] = a.here
+	case ":const":
+		name := a.next()
+		a.consts[name] = a.number(a.next(), -128, 0xFFF)
+	case ":alias":
+		name := a.next()
+		a.aliases[name] = a.register(a.next())
+	case ":org":
+		a.here = a.number(a.next(), 0x200, 0xFFF)
+	case ":unpack":
+		hi := a.number(a.next(), 0, 0xF)
+		a.address(uint16(hi)<<12, a.next())
+	case ":call":
+		a.address(0x2000, a.next())
+	case ":byte":
+		a.emit(a.byteValue(a.next()))
+	case ":breakpoint":
+		a.next()
+	case ":monitor":
+		a.next()
+		a.next()
+	case "return", ";":
+		a.inst(0x00EE)
+	case "clear":
+		a.inst(0x00E0)
+	case "bcd", "save", "load":
+		x := a.register(a.next())
+		if a.peek() == "-" {
+			a.fail("%s of a register range is xo-chip, unsupported", t)
+		}
+		a.inst(map[string]uint16{"bcd": 0xF033, "save": 0xF055, "load": 0xF065}[t] | x<<8)
+	case "sprite":
+		x := a.register(a.next())
+		y := a.register(a.next())
+		n := a.number(a.next(), 0, 0xF)
+		a.inst(0xD000 | x<<8 | y<<4 | uint16(n))
+	case "jump":
+		a.address(0x1000, a.next())
+	case "jump0":
+		a.address(0xB000, a.next())
+	case "native":
+		a.address(0x0000, a.next())
+	case "delay", "buzzer":
+		a.expect(":=")
+		x := a.register(a.next())
+		if t == "delay" {
+			a.inst(0xF015 | x<<8)
+		} else {
+			a.inst(0xF018 | x<<8)
+		}
+	case "i":
+		a.index()
+	case "if":
+		a.ifStatement()
+	case "else":
+		f := a.pop(t, "begin")
+		a.flow = append(a.flow, octoFlow{kind: "else", at: a.here, line: a.toks[a.pos-1].line})
+		a.inst(0x1000)
+		a.patch(f.at, a.here)
+	case "end":
+		f := a.pop(t, "begin", "else")
+		a.patch(f.at, a.here)
+	case "loop":
+		a.flow = append(a.flow, octoFlow{kind: "loop", at: a.here, line: a.toks[a.pos-1].line})
+	case "while":
+		k := len(a.flow) - 1
+		for k >= 0 && a.flow[k].kind != "loop" {
+			k--
+		}
+		if k < 0 {
+			a.fail("while outside a loop")
+		}
+		a.condition(true)
+		a.flow[k].breaks = append(a.flow[k].breaks, a.here)
+		a.inst(0x1000)
+	case "again":
+		f := a.pop(t, "loop")
+		a.inst(0x1000 | uint16(f.at))
+		for _, at := range f.breaks {
+			a.patch(at, a.here)
+		}
+	default:
+		if x, ok := a.isRegister(t); ok {
+			a.registerOp(x)
+			return
+		}
+		if v, ok := a.constant(t); ok {
+			if v < -128 || v > 255 {
+				a.fail("bad byte %q", t)
+			}
+			a.emit(byte(v))
+			return
+		}
+		if octoUnsupported[t] {
+			a.fail("%s is schip or xo-chip, unsupported", t)
+		}
+		if strings.HasPrefix(t, ":") {
+			a.fail("%s is unsupported", t)
+		}
+		a.address(0x2000, t) // a bare name calls it
+	}
+}
+
+// close the innermost if or loop, which must be one of kinds
+func (a *octoAssembler) pop(word string, kinds ...string) octoFlow {
+	if len(a.flow) > 0 {
+		f := a.flow[len(a.flow)-1]
+		for _, k := range kinds {
+			if f.kind == k {
+				a.flow = a.flow[:len(a.flow)-1]
+				return f
+			}
+		}
+	}
+	a.fail("%s without %s", word, strings.Join(kinds, " or "))
+	return octoFlow{}
+}
+
+func (a *octoAssembler) index() {
+	switch op := a.next(); op {
+	case ":=":
+		t := a.next()
+		switch {
+		case t == "hex":
+			a.inst(0xF029 | a.register(a.next())<<8)
+		case octoUnsupported[t]:
+			a.fail("i := %s is schip or xo-chip, unsupported", t)
+		default:
+			a.address(0xA000, t)
+		}
+	case "+=":
+		a.inst(0xF01E | a.register(a.next())<<8)
+	default:
+		a.fail("unknown operator i %s", op)
+	}
+}
+
+func (a *octoAssembler) registerOp(x uint16) {
+	op := a.next()
+	t := a.next()
+	y, isReg := a.isRegister(t)
+	alu := map[string]uint16{"|=": 0x1, "&=": 0x2, "^=": 0x3, ">>=": 0x6, "=-": 0x7, "<<=": 0xE}
+	switch {
+	case op == ":=" && isReg:
+		a.inst(0x8000 | x<<8 | y<<4)
+	case op == ":=" && t == "key":
+		a.inst(0xF00A | x<<8)
+	case op == ":=" && t == "delay":
+		a.inst(0xF007 | x<<8)
+	case op == ":=" && t == "random":
+		a.inst(0xC000 | x<<8 | uint16(a.byteValue(a.next())))
+	case op == ":=":
+		a.inst(0x6000 | x<<8 | uint16(a.byteValue(t)))
+	case op == "+=" && isReg:
+		a.inst(0x8004 | x<<8 | y<<4)
+	case op == "+=":
+		a.inst(0x7000 | x<<8 | uint16(a.byteValue(t)))
+	case op == "-=" && isReg:
+		a.inst(0x8005 | x<<8 | y<<4)
+	case op == "-=":
+		a.inst(0x7000 | x<<8 | uint16(-a.byteValue(t)))
+	case alu[op] != 0:
+		if !isReg {
+			a.fail("%s takes a register, got %q", op, t)
+		}
+		a.inst(0x8000 | x<<8 | y<<4 | alu[op])
+	default:
+		a.fail("unknown operator %q", op)
+	}
+}
+
+// if the condition is followed by then, skip the next statement when it
+// is false; if by begin, jump to the matching else or end
+func (a *octoAssembler) ifStatement() {
+	n := 3
+	if k := a.pos + 1; k < len(a.toks) && (a.toks[k].text == "key" || a.toks[k].text == "-key") {
+		n = 2
+	}
+	if a.pos+n >= len(a.toks) {
+		a.fail("if without then or begin")
+	}
+	switch word := a.toks[a.pos+n].text; word {
+	case "then":
+		a.condition(false)
+		a.next()
+	case "begin":
+		a.condition(true)
+		a.next()
+		a.flow = append(a.flow, octoFlow{kind: "begin", at: a.here, line: a.toks[a.pos-1].line})
+		a.inst(0x1000)
+	default:
+		a.fail("expected then or begin, got %q", word)
+	}
+}
+
+// a skip over the next instruction when the condition is true, or when
+// it is false. <, >, <= and >= go through v[F] as octo's do, relying on
+// the flag being written last.
+func (a *octoAssembler) condition(skipWhenTrue bool) {
+	x := a.register(a.next())
+	op := a.next()
+	switch op {
+	case "key", "-key":
+		if (op == "key") == skipWhenTrue {
+			a.inst(0xE09E | x<<8)
+		} else {
+			a.inst(0xE0A1 | x<<8)
+		}
+	case "==", "!=":
+		t := a.next()
+		y, isReg := a.isRegister(t)
+		skipEqual := (op == "==") == skipWhenTrue
+		switch {
+		case isReg && skipEqual:
+			a.inst(0x5000 | x<<8 | y<<4)
+		case isReg:
+			a.inst(0x9000 | x<<8 | y<<4)
+		case skipEqual:
+			a.inst(0x3000 | x<<8 | uint16(a.byteValue(t)))
+		default:
+			a.inst(0x4000 | x<<8 | uint16(a.byteValue(t)))
+		}
+	case "<", ">", "<=", ">=":
+		t := a.next()
+		if y, ok := a.isRegister(t); ok {
+			a.inst(0x8F00 | y<<4)
+		} else {
+			a.inst(0x6F00 | uint16(a.byteValue(t)))
+		}
+		// v[F] - v[x] sets the flag only when the operand is above x,
+		// v[x] - v[F] only when x is above the operand
+		if op == "<" || op == ">=" {
+			a.inst(0x8F05 | x<<4)
+		} else {
+			a.inst(0x8F07 | x<<4)
+		}
+		// < and > hold when the flag is set, >= and <= when it is clear
+		if (op == "<" || op == ">") == skipWhenTrue {
+			a.inst(0x4F00)
+		} else {
+			a.inst(0x3F00)
+		}
+	default:
+		a.fail("unknown comparison %q", op)
+	}
+}
+
+// a jump, call or other instruction taking an address, from a number,
+// constant or label, the label perhaps defined later
+func (a *octoAssembler) address(op uint16, t string) {
+	if v, ok := a.constant(t); ok {
+		if v < 0 || v > 0xFFF {
+			a.fail("address %q out of range", t)
+		}
+		a.inst(op | uint16(v))
+		return
+	}
+	if addr, ok := a.labels[t]; ok {
+		a.inst(op | uint16(addr))
+		return
+	}
+	if !octoName(t) {
+		a.fail("unexpected %q", t)
+	}
+	a.fixups = append(a.fixups, octoFixup{a.here, t, a.toks[a.pos-1].line})
+	a.inst(op)
+}
+
+func (a *octoAssembler) register(t string) uint16 {
+	x, ok := a.isRegister(t)
+	if !ok {
+		a.fail("expected a register, got %q", t)
+	}
+	return x
+}
+
+func (a *octoAssembler) isRegister(t string) (uint16, bool) {
+	if x, ok := a.aliases[t]; ok {
+		return x, true
+	}
+	if len(t) != 2 || (t[0] != 'v' && t[0] != 'V') {
+		return 0, false
+	}
+	x, err := strconv.ParseUint(t[1:], 16, 4)
+	return uint16(x), err == nil
+}
+
+func (a *octoAssembler) constant(t string) (int, bool) {
+	if v, ok := octoNumber(t); ok {
+		return v, true
+	}
+	v, ok := a.consts[t]
+	return v, ok
+}
+
+func (a *octoAssembler) number(t string, min, max int) int {
+	v, ok := a.constant(t)
+	if !ok || v < min || v > max {
+		a.fail("bad number %q", t)
+	}
+	return v
+}
+
+func (a *octoAssembler) byteValue(t string) byte {
+	return byte(a.number(t, -128, 255))
+}
+
+// an octo number literal: hex, binary or decimal, perhaps negative
+func octoNumber(s string) (int, bool) {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	var v int64
+	var err error
+	switch {
+	case strings.HasPrefix(s, "0x"):
+		v, err = strconv.ParseInt(s[2:], 16, 32)
+	case strings.HasPrefix(s, "0b"):
+		v, err = strconv.ParseInt(s[2:], 2, 32)
+	default:
+		v, err = strconv.ParseInt(s, 10, 32)
+	}
+	if err != nil || strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		return 0, false
+	}
+	if neg {
+		v = -v
+	}
+	return int(v), true
+}
+
+// names octo allows for labels and constants
+func octoName(s string) bool {
+	for k, r := range s {
+		letter := r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		if !letter && (k == 0 || r != '-' && (r < '0' || r > '9')) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// handler for a single instruction
+type handler func(c *cpu, opcode uint16) error
+
+// decoded instruction
+type instruction struct {
+	name    string  // generic name of instruction
+	cPseudo string  // c pseudo code
+	exec    handler // implementation
+}
+
+// dispatch table indexed by opcode, built once at startup
+var dispatch [0x10000]*instruction
+
+func init() {
+	for opcode := 0; opcode < len(dispatch); opcode++ {
+		dispatch[opcode] = decode(uint16(opcode))
+	}
+}
+
+// operand helpers
+func opX(opcode uint16) uint8    { return uint8((opcode & 0x0F00) >> 8) } // x operand
+func opY(opcode uint16) uint8    { return uint8((opcode & 0x00F0) >> 4) } // y operand
+func opN(opcode uint16) uint8    { return uint8(opcode & 0x000F) }        // nibble
+func opKK(opcode uint16) uint8   { return uint8(opcode & 0x00FF) }        // byte
+func opNNN(opcode uint16) uint16 { return opcode & 0x0FFF }               // addr
+
+// program fault that stops the machine
+type trap struct {
+	kind   string // unknown opcode, stack overflow, stack underflow, pc or i out of range
+	opcode uint16 // or pc or i, when it ran off the end of memory
+}
+
+func (t *trap) Error() string {
+	return fmt.Sprintf("fatal error: %s 0x%X", t.kind, t.opcode)
+}
+
+var unknown = &instruction{"????", "/* unknown */", func(c *cpu, opcode uint16) error {
+	return &trap{"unknown opcode", opcode}
+}}
+
+var (
+	op00E0 = &instruction{"00E0", "clear()", func(c *cpu, opcode uint16) error {
+		for i := 0; i < 32; i++ {
+			for j := 0; j < 64; j++ {
+				c.disp[i][j] = 0x00
+			}
+		}
+		c.dirty = allRows
+		return nil
+	}}
+	op00EE = &instruction{"00EE", "return", func(c *cpu, opcode uint16) error {
+		if c.sp == 0 {
+			return &trap{"stack underflow", opcode}
+		}
+		c.sp -= 1
+		c.pc = c.stack[c.sp]
+		c.stack[c.sp] = 0x00
+		return nil
+	}}
+	op1NNN = &instruction{"1NNN", "jump", func(c *cpu, opcode uint16) error {
+		c.pc = opNNN(opcode)
+		return nil
+	}}
+	op2NNN = &instruction{"2NNN", "function call", func(c *cpu, opcode uint16) error {
+		if int(c.sp) >= len(c.stack) {
+			return &trap{"stack overflow", opcode}
+		}
+		c.stack[c.sp] = c.pc
+		c.sp += 1
+		c.pc = opNNN(opcode)
+		return nil
+	}}
+	op3XKK = &instruction{"3XKK", "if v[x] == kk: continue", func(c *cpu, opcode uint16) error {
+		if c.v[opX(opcode)] == opKK(opcode) {
+			c.pc += 2
+		}
+		return nil
+	}}
+	op4XKK = &instruction{"4XKK", "if v[x] != kk: continue", func(c *cpu, opcode uint16) error {
+		if c.v[opX(opcode)] != opKK(opcode) {
+			c.pc += 2
+		}
+		return nil
+	}}
+	op5XY0 = &instruction{"5XY0", "if v[x] == v[y]: continue", func(c *cpu, opcode uint16) error {
+		if c.v[opX(opcode)] == c.v[opY(opcode)] {
+			c.pc += 2
+		}
+		return nil
+	}}
+	op6XKK = &instruction{"6XKK", "v[x] = kk", func(c *cpu, opcode uint16) error {
+		c.v[opX(opcode)] = opKK(opcode)
+		return nil
+	}}
+	op7XKK = &instruction{"7XKK", "v[x] = v[x] + kk", func(c *cpu, opcode uint16) error {
+		x := opX(opcode)
+		c.v[x] = c.v[x] + opKK(opcode)
+		return nil
+	}}
+	op8XY0 = &instruction{"8XY0", "v[x] = v[y]", func(c *cpu, opcode uint16) error {
+		c.v[opX(opcode)] = c.v[opY(opcode)]
+		return nil
+	}}
+	op8XY1 = &instruction{"8XY1", "v[x] = v[x] | v[y]", func(c *cpu, opcode uint16) error {
+		x := opX(opcode)
+		c.v[x] = (c.v[x] | c.v[opY(opcode)])
+		c.logicQuirk()
+		return nil
+	}}
+	op8XY2 = &instruction{"8XY2", "v[x] = v[x] & v[y]", func(c *cpu, opcode uint16) error {
+		x := opX(opcode)
+		c.v[x] = (c.v[x] & c.v[opY(opcode)])
+		c.logicQuirk()
+		return nil
+	}}
+	op8XY3 = &instruction{"8XY3", "v[x] = v[x] ^ v[y]", func(c *cpu, opcode uint16) error {
+		x := opX(opcode)
+		c.v[x] = (c.v[x] ^ c.v[opY(opcode)])
+		c.logicQuirk()
+		return nil
+	}}
+	op8XY4 = &instruction{"8XY4", "if v[x] + v[y] > 0xFF: v[F] = 1 else: v[F] = 0; v[x] = v[x] + v[y]", func(c *cpu, opcode uint16) error {
+		x, y := opX(opcode), opY(opcode)
+		var flag uint8
+		if uint16(c.v[x])+uint16(c.v[y]) > 0xFF {
+			flag = 0x01
+		}
+		c.setWithFlag(x, c.v[x]+c.v[y], flag)
+		return nil
+	}}
+	op8XY5 = &instruction{"8XY5", "if v[x] > v[y]: v[F] = 1 else: v[F] = 0; v[x] = v[x] - v[y]", func(c *cpu, opcode uint16) error {
+		x, y := opX(opcode), opY(opcode)
+		var flag uint8
+		if c.v[x] > c.v[y] {
+			flag = 0x01
+		}
+		c.setWithFlag(x, c.v[x]-c.v[y], flag)
+		return nil
+	}}
+	op8XY6 = &instruction{"8XY6", "if v[x] & 0x01: v[F] = 1 else: v[F] = 0; v[x] = v[x] / 2", func(c *cpu, opcode uint16) error {
+		x := opX(opcode)
+		src := c.v[x]
+		if c.quirks.shift {
+			src = c.v[opY(opcode)]
+		}
+		c.setWithFlag(x, src/2, src&0x01)
+		return nil
+	}}
+	op8XY7 = &instruction{"8XY7", "if v[y] > v[x]: v[F] = 1 else: v[F] = 0; v[x] = v[y] - v[x]", func(c *cpu, opcode uint16) error {
+		x, y := opX(opcode), opY(opcode)
+		var flag uint8
+		if c.v[y] > c.v[x] {
+			flag = 0x01
+		}
+		c.setWithFlag(x, c.v[y]-c.v[x], flag)
+		return nil
+	}}
+	op8XYE = &instruction{"8XYE", "if v[x] >> 7 == 1: v[F] = 1 else: v[F] = 0; v[x] = v[x] * 2", func(c *cpu, opcode uint16) error {
+		x := opX(opcode)
+		src := c.v[x]
+		if c.quirks.shift {
+			src = c.v[opY(opcode)]
+		}
+		c.setWithFlag(x, src*2, src>>7)
+		return nil
+	}}
+	op9XY0 = &instruction{"9XY0", "if v[x] != v[y]: pc = pc + 2", func(c *cpu, opcode uint16) error {
+		if c.v[opX(opcode)] != c.v[opY(opcode)] {
+			c.pc += 2
+		}
+		return nil
+	}}
+	opANNN = &instruction{"ANNN", "i = nnn", func(c *cpu, opcode uint16) error {
+		c.i = opNNN(opcode)
+		return nil
+	}}
+	opBNNN = &instruction{"BNNN", "pc = v[0] + nnn", func(c *cpu, opcode uint16) error {
+		if c.quirks.jump {
+			c.pc = uint16(c.v[opX(opcode)]) + opNNN(opcode)
+			return nil
+		}
+		c.pc = uint16(c.v[0x0]) + opNNN(opcode)
+		return nil
+	}}
+	opCXKK = &instruction{"CXKK", "v[x] = rand-byte & kk", func(c *cpu, opcode uint16) error {
+		c.v[opX(opcode)] = c.random() & opKK(opcode)
+		return nil
+	}}
+	opDXYN = &instruction{"DXYN", "/* write n-rows of sprite to disp */", func(c *cpu, opcode uint16) error {
+		x, y, n := opX(opcode), opY(opcode), opN(opcode)
+		if err := c.checkI(int(n)); err != nil {
+			return err
+		}
+
+		// assume no pixels will be erased
+		c.v[0xF] = 0x00
+		c.drew = true
+		c.profileRead(c.i, int(n))
+		c.profileSprite(c.i, n)
+
+		// iterate through sprite rows
+		var rows uint8
+		for rows = 0; rows < n; rows++ {
+			// iterate through bits of sprite
+			var cols uint8
+			for cols = 0; cols < 8; cols++ {
+				// handle x wrap, sprites start on screen even when clipped
+				dispX := c.v[x]%64 + cols
+				if dispX >= 64 {
+					if c.quirks.clip {
+						continue
+					}
+					dispX -= 64
+				}
+
+				// handle y wrap
+				dispY := c.v[y]%32 + rows
+				if dispY >= 32 {
+					if c.quirks.clip {
+						continue
+					}
+					dispY -= 32
+				}
+
+				// was the pixel on?
+				pixelWasOn := c.disp[dispY][dispX] > 0
+
+				// write to display
+				// how?
+				// get the sprite row from memory
+				// bit shift it to the left for the correct pixel
+				// mask it with 0x80 to get only the leftmost bit
+				// shift that bit all the way back to the right to get a 1 or 0
+				pixel := ((uint8(c.mem[c.i+uint16(rows)]) << cols) & 0x80) >> 0x07
+				c.disp[dispY][dispX] = c.disp[dispY][dispX] ^ pixel
+				c.dirty |= 1 << dispY
+
+				// is the pixel now off?
+				pixelNowOff := c.disp[dispY][dispX] == 0
+
+				// flag VF if any pixels were erased
+				if pixelWasOn && pixelNowOff {
+					c.v[0xF] = 0x01
+				}
+			}
+		}
+		return nil
+	}}
+	opEX9E = &instruction{"EX9E", "if keys[v[x]] == DOWN: pc += 2", func(c *cpu, opcode uint16) error {
+		keyIsDown := c.keys[int(c.v[opX(opcode)])] == 1
+		if keyIsDown {
+			c.pc += 2
+		}
+		return nil
+	}}
+	opEXA1 = &instruction{"EXA1", "if keys[v[x]] == UP: pc += 2", func(c *cpu, opcode uint16) error {
+		keyIsUp := c.keys[int(c.v[opX(opcode)])] == 0
+		if keyIsUp {
+			c.pc += 2
+		}
+		return nil
+	}}
+	opFX07 = &instruction{"FX07", "v[x] = dt", func(c *cpu, opcode uint16) error {
+		c.v[opX(opcode)] = c.dt
+		return nil
+	}}
+	opFX0A = &instruction{"FX0A", "v[x] = getKey()", func(c *cpu, opcode uint16) error {
+		// without a key, run this instruction again next time
+		if c.waitKeyPlugin == nil {
+			c.pc -= 2
+			return nil
+		}
+		k, ok := c.waitKeyPlugin()
+		if !ok {
+			c.pc -= 2
+			return nil
+		}
+		c.v[opX(opcode)] = k
+		return nil
+	}}
+	opFX15 = &instruction{"FX15", "dt = v[x]", func(c *cpu, opcode uint16) error {
+		c.dt = c.v[opX(opcode)]
+		return nil
+	}}
+	opFX18 = &instruction{"FX18", "st = v[x]", func(c *cpu, opcode uint16) error {
+		c.st = c.v[opX(opcode)]
+		return nil
+	}}
+	opFX1E = &instruction{"FX1E", "i += v[x]", func(c *cpu, opcode uint16) error {
+		c.i += uint16(c.v[opX(opcode)])
+		return nil
+	}}
+	opFX29 = &instruction{"FX29", "i = &SPRITE(v[x])", func(c *cpu, opcode uint16) error {
+		c.i = c.layout.font + 5*uint16(c.v[opX(opcode)]&0x0F)
+		return nil
+	}}
+	opFX33 = &instruction{"FX33", "mem[i], mem[i+1], mem[i+2] = BCD(v[x])", func(c *cpu, opcode uint16) error {
+		x := opX(opcode)
+		if err := c.checkI(3); err != nil {
+			return err
+		}
+		c.profileWrite(c.i, 3)
+		c.mem[c.i] = c.v[x] / 100
+		c.mem[c.i+1] = (c.v[x] % 100) / 10
+		c.mem[c.i+2] = ((c.v[x] % 100) % 10) / 1
+		return nil
+	}}
+	opFX55 = &instruction{"FX55", "mem[i:i+x] = v[0:x]", func(c *cpu, opcode uint16) error {
+		x := opX(opcode)
+		if err := c.checkI(int(x) + 1); err != nil {
+			return err
+		}
+		c.profileWrite(c.i, int(x)+1)
+		var j uint8
+		for j = 0; j <= x; j++ {
+			c.mem[c.i+uint16(j)] = c.v[j]
+		}
+		if c.quirks.memory {
+			c.i += uint16(x) + 1
+		}
+		return nil
+	}}
+	opFX65 = &instruction{"FX65", "v[0:x] = mem[i:i+x]", func(c *cpu, opcode uint16) error {
+		x := opX(opcode)
+		if err := c.checkI(int(x) + 1); err != nil {
+			return err
+		}
+		c.profileRead(c.i, int(x)+1)
+		var j uint8
+		for j = 0; j <= x; j++ {
+			c.v[j] = c.mem[c.i+uint16(j)]
+		}
+		if c.quirks.memory {
+			c.i += uint16(x) + 1
+		}
+		return nil
+	}}
+)
+
+// the VIP's logic instructions leave v[F] cleared
+func (c *cpu) logicQuirk() {
+	if c.quirks.vfReset {
+		c.v[0xF] = 0x00
+	}
+}
+
+// a trap unless the n bytes at i are all in memory
+func (c *cpu) checkI(n int) error {
+	if int(c.i)+n > len(c.mem) {
+		return &trap{"i out of range", c.i}
+	}
+	return nil
+}
+
+// write an arithmetic result and its v[F] flag, the later one winning
+// when x is F
+func (c *cpu) setWithFlag(x, result, flag uint8) {
+	if c.quirks.flagLast {
+		c.v[x] = result
+		c.v[0xF] = flag
+		return
+	}
+	c.v[0xF] = flag
+	c.v[x] = result
+}
+
+// random byte from the cpu's source, or the global one
+func (c *cpu) random() uint8 {
+	if c.rng != nil {
+		return uint8(c.rng.Uint32())
+	}
+	return uint8(rand.Uint32())
+}
+
+// decode opcode into its instruction, used to build the dispatch table
+func decode(opcode uint16) *instruction {
+	switch opcode & 0xF000 {
+	case 0x0000:
+		switch opcode {
+		case 0x00E0:
+			return op00E0
+		case 0x00EE:
+			return op00EE
+		}
+	case 0x1000:
+		return op1NNN
+	case 0x2000:
+		return op2NNN
+	case 0x3000:
+		return op3XKK
+	case 0x4000:
+		return op4XKK
+	case 0x5000:
+		if opN(opcode) == 0x0 {
+			return op5XY0
+		}
+	case 0x6000:
+		return op6XKK
+	case 0x7000:
+		return op7XKK
+	case 0x8000:
+		switch opN(opcode) {
+		case 0x0:
+			return op8XY0
+		case 0x1:
+			return op8XY1
+		case 0x2:
+			return op8XY2
+		case 0x3:
+			return op8XY3
+		case 0x4:
+			return op8XY4
+		case 0x5:
+			return op8XY5
+		case 0x6:
+			return op8XY6
+		case 0x7:
+			return op8XY7
+		case 0xE:
+			return op8XYE
+		}
+	case 0x9000:
+		if opN(opcode) == 0x0 {
+			return op9XY0
+		}
+	case 0xA000:
+		return opANNN
+	case 0xB000:
+		return opBNNN
+	case 0xC000:
+		return opCXKK
+	case 0xD000:
+		return opDXYN
+	case 0xE000:
+		switch opKK(opcode) {
+		case 0x9E:
+			return opEX9E
+		case 0xA1:
+			return opEXA1
+		}
+	case 0xF000:
+		switch opKK(opcode) {
+		case 0x07:
+			return opFX07
+		case 0x0A:
+			return opFX0A
+		case 0x15:
+			return opFX15
+		case 0x18:
+			return opFX18
+		case 0x1E:
+			return opFX1E
+		case 0x29:
+			return opFX29
+		case 0x33:
+			return opFX33
+		case 0x55:
+			return opFX55
+		case 0x65:
+			return opFX65
+		}
+	}
+	return unknown
+}
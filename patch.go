@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// ips patches are "PATCH", then records of a 3 byte offset and 2 byte
+// size followed by that many bytes, or a zero size, 2 byte count and a
+// byte repeated count times, then "EOF" and an optional 3 byte length to
+// truncate to. they carry no checksums.
+var (
+	ipsMagic = []byte("PATCH")
+	ipsEOF   = []byte("EOF")
+)
+
+// bps patches are "BPS1", the source, target and metadata sizes, the
+// metadata, then actions each copying a run of bytes from the source or
+// target to the target, and the crc32s of source, target and patch.
+var bpsMagic = []byte("BPS1")
+
+// bps actions, in the low two bits of each action number
+const (
+	bpsSourceRead = iota // source bytes at the same offset
+	bpsTargetRead        // bytes carried in the patch
+	bpsSourceCopy        // source bytes from a relative offset
+	bpsTargetCopy        // earlier target bytes from a relative offset
+)
+
+// the rom with an ips or bps patch applied, told apart by magic
+func applyPatch(rom, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return applyIPS(rom, patch)
+	case bytes.HasPrefix(patch, bpsMagic):
+		return applyBPS(rom, patch)
+	}
+	return nil, fmt.Errorf("patch: neither ips nor bps")
+}
+
+func applyIPS(rom, patch []byte) ([]byte, error) {
+	out := append([]byte{}, rom...)
+	p := patch[len(ipsMagic):]
+	short := fmt.Errorf("patch: ips cut short")
+	for {
+		if len(p) < 3 {
+			return nil, short
+		}
+		if bytes.Equal(p[:3], ipsEOF) {
+			p = p[3:]
+			break
+		}
+		if len(p) < 5 {
+			return nil, short
+		}
+		offset := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		size := int(p[3])<<8 | int(p[4])
+		p = p[5:]
+		var data []byte
+		if size == 0 {
+			if len(p) < 3 {
+				return nil, short
+			}
+			data = bytes.Repeat(p[2:3], int(p[0])<<8|int(p[1]))
+			p = p[3:]
+		} else {
+			if len(p) < size {
+				return nil, short
+			}
+			data, p = p[:size], p[size:]
+		}
+		end := offset + len(data)
+		if end > maxROMSize {
+			return nil, fmt.Errorf("patch: rom larger than %d bytes", maxROMSize)
+		}
+		if end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+	if len(p) >= 3 {
+		if n := int(p[0])<<16 | int(p[1])<<8 | int(p[2]); n < len(out) {
+			out = out[:n]
+		}
+	}
+	return out, nil
+}
+
+// bps numbers, seven bits a byte, the last byte flagged by its top bit
+func bpsNumber(p []byte) (uint64, []byte, error) {
+	var n uint64
+	shift := uint64(1)
+	for k, b := range p {
+		if k > 9 {
+			break
+		}
+		n += uint64(b&0x7F) * shift
+		if b&0x80 != 0 {
+			return n, p[k+1:], nil
+		}
+		shift <<= 7
+		n += shift
+	}
+	return 0, nil, fmt.Errorf("patch: bad bps number")
+}
+
+func appendBPSNumber(out []byte, n uint64) []byte {
+	for {
+		b := byte(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			return append(out, 0x80|b)
+		}
+		out = append(out, b)
+		n--
+	}
+}
+
+// checks the source, patch and target against the patch's crc32s
+func applyBPS(rom, patch []byte) ([]byte, error) {
+	if len(patch) < len(bpsMagic)+12 {
+		return nil, fmt.Errorf("patch: bps cut short")
+	}
+	footer := patch[len(patch)-12:]
+	sourceCRC := binary.LittleEndian.Uint32(footer[0:])
+	targetCRC := binary.LittleEndian.Uint32(footer[4:])
+	patchCRC := binary.LittleEndian.Uint32(footer[8:])
+	if got := crc32.ChecksumIEEE(patch[:len(patch)-4]); got != patchCRC {
+		return nil, fmt.Errorf("patch: bps damaged, crc32 %08X, expected %08X", got, patchCRC)
+	}
+	if got := crc32.ChecksumIEEE(rom); got != sourceCRC {
+		return nil, fmt.Errorf("patch: made for another rom, crc32 %08X, expected %08X", got, sourceCRC)
+	}
+
+	p := patch[len(bpsMagic) : len(patch)-12]
+	var sizes [3]uint64 // source, target, metadata
+	for k := range sizes {
+		var err error
+		if sizes[k], p, err = bpsNumber(p); err != nil {
+			return nil, err
+		}
+	}
+	if sizes[0] != uint64(len(rom)) || sizes[1] > maxROMSize || sizes[2] > uint64(len(p)) {
+		return nil, fmt.Errorf("patch: bad bps sizes")
+	}
+	p = p[sizes[2]:]
+
+	out := make([]byte, 0, sizes[1])
+	var sourceAt, targetAt int
+	bad := fmt.Errorf("patch: bad bps action")
+	for len(p) > 0 {
+		action, rest, err := bpsNumber(p)
+		if err != nil {
+			return nil, err
+		}
+		p = rest
+		n := int(action>>2) + 1
+		if uint64(len(out)+n) > sizes[1] {
+			return nil, bad
+		}
+		switch action & 3 {
+		case bpsSourceRead:
+			if len(out)+n > len(rom) {
+				return nil, bad
+			}
+			out = append(out, rom[len(out):len(out)+n]...)
+		case bpsTargetRead:
+			if n > len(p) {
+				return nil, bad
+			}
+			out, p = append(out, p[:n]...), p[n:]
+		case bpsSourceCopy, bpsTargetCopy:
+			d, rest, err := bpsNumber(p)
+			if err != nil {
+				return nil, err
+			}
+			p = rest
+			delta := int(d >> 1)
+			if d&1 != 0 {
+				delta = -delta
+			}
+			if action&3 == bpsSourceCopy {
+				sourceAt += delta
+				if sourceAt < 0 || sourceAt+n > len(rom) {
+					return nil, bad
+				}
+				out = append(out, rom[sourceAt:sourceAt+n]...)
+				sourceAt += n
+				continue
+			}
+			// target copies may overlap what they write, byte by byte
+			targetAt += delta
+			if targetAt < 0 || targetAt >= len(out) {
+				return nil, bad
+			}
+			for k := 0; k < n; k++ {
+				out = append(out, out[targetAt])
+				targetAt++
+			}
+		}
+	}
+	if uint64(len(out)) != sizes[1] {
+		return nil, fmt.Errorf("patch: bps wrote %d bytes, expected %d", len(out), sizes[1])
+	}
+	if got := crc32.ChecksumIEEE(out); got != targetCRC {
+		return nil, fmt.Errorf("patch: result crc32 %08X, expected %08X", got, targetCRC)
+	}
+	return out, nil
+}
+
+// an ips patch turning source into target
+func createIPS(source, target []byte) ([]byte, error) {
+	if len(target) > 0xFFFFFF {
+		return nil, fmt.Errorf("patch: target too large for ips")
+	}
+	out := append([]byte{}, ipsMagic...)
+	for k := 0; k < len(target); {
+		if k < len(source) && source[k] == target[k] {
+			k++
+			continue
+		}
+
+		// the changed run, kept clear of an offset that reads as EOF
+		start := k
+		if start == 0x454F46 {
+			start--
+		}
+		for k < len(target) && k-start < 0xFFFF && (k >= len(source) || source[k] != target[k]) {
+			k++
+		}
+		out = append(out, byte(start>>16), byte(start>>8), byte(start), byte((k-start)>>8), byte(k-start))
+		out = append(out, target[start:k]...)
+	}
+	out = append(out, ipsEOF...)
+	if len(target) < len(source) {
+		out = append(out, byte(len(target)>>16), byte(len(target)>>8), byte(len(target)))
+	}
+	return out, nil
+}
+
+// a bps patch turning source into target, reading matching bytes from
+// the source and carrying the rest
+func createBPS(source, target []byte) []byte {
+	out := append([]byte{}, bpsMagic...)
+	out = appendBPSNumber(out, uint64(len(source)))
+	out = appendBPSNumber(out, uint64(len(target)))
+	out = appendBPSNumber(out, 0)
+	same := func(k int) bool { return k < len(source) && source[k] == target[k] }
+	for k := 0; k < len(target); {
+		start := k
+		match := same(k)
+		for k < len(target) && same(k) == match {
+			k++
+		}
+		action := uint64(bpsTargetRead)
+		if match {
+			action = bpsSourceRead
+		}
+		out = appendBPSNumber(out, uint64(k-start-1)<<2|action)
+		if !match {
+			out = append(out, target[start:k]...)
+		}
+	}
+	out = appendCRC(out, crc32.ChecksumIEEE(source))
+	out = appendCRC(out, crc32.ChecksumIEEE(target))
+	return appendCRC(out, crc32.ChecksumIEEE(out))
+}
+
+func appendCRC(out []byte, crc uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], crc)
+	return append(out, b[:]...)
+}
+
+// chip8 patch create [-format ips|bps] [-o file] original modified
+// chip8 patch apply [-o file] rom patch
+func patchCmd(args []string) error {
+	if len(args) == 0 || (args[0] != "create" && args[0] != "apply") {
+		return fmt.Errorf("patch: expected create or apply")
+	}
+	fs := flag.NewFlagSet("patch "+args[0], flag.ContinueOnError)
+	outPath := fs.String("o", "", "output file, next to the second file if empty")
+	format := fs.String("format", "bps", "patch format for create: bps or ips")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("patch %s: expected two files, got %d", args[0], fs.NArg())
+	}
+	first, err := loadROM(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	second := fs.Arg(1)
+	base := strings.TrimSuffix(second, filepath.Ext(second))
+
+	var out []byte
+	if args[0] == "create" {
+		target, err := loadROM(second, nil)
+		if err != nil {
+			return err
+		}
+		switch *format {
+		case "bps":
+			out = createBPS(first.data, target.data)
+		case "ips":
+			if out, err = createIPS(first.data, target.data); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("patch: unknown format %q", *format)
+		}
+		if *outPath == "" {
+			*outPath = base + "." + *format
+		}
+	} else {
+		patch, err := ioutil.ReadFile(second)
+		if err != nil {
+			return err
+		}
+		if out, err = applyPatch(first.data, patch); err != nil {
+			return err
+		}
+		// a cartridge is patched as the program it holds
+		ext := filepath.Ext(first.name)
+		if first.cart != nil {
+			ext = ".ch8"
+		}
+		if *outPath == "" {
+			*outPath = filepath.Join(filepath.Dir(second), strings.TrimSuffix(first.name, filepath.Ext(first.name))+"-patched"+ext)
+		}
+	}
+	return ioutil.WriteFile(*outPath, out, 0644)
+}
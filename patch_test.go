@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	source := []byte{0x00, 0xE0, 0xA2, 0x2A, 0x60, 0x0C, 0x61, 0x08, 0xD0, 0x1F, 0x12, 0x0A}
+	cases := []struct {
+		desc   string
+		target []byte
+	}{
+		{"same", source},
+		{"changed", []byte{0x00, 0xE0, 0xA2, 0x2A, 0x60, 0x1C, 0x61, 0x08, 0xD0, 0x1F, 0x12, 0x00}},
+		{"longer", append(append([]byte{}, source...), 0xF0, 0x90, 0xF0)},
+		{"shorter", source[:6]},
+		{"empty", []byte{}},
+	}
+	for _, tc := range cases {
+		ips, err := createIPS(source, tc.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for format, patch := range map[string][]byte{"ips": ips, "bps": createBPS(source, tc.target)} {
+			got, err := applyPatch(source, patch)
+			if err != nil || !bytes.Equal(got, tc.target) {
+				t.Fatalf("fatal patch error for %s %s: expected % X, got % X (%v)", tc.desc, format, tc.target, got, err)
+			}
+		}
+	}
+
+	// bps checks its crcs, ips has none
+	bps := createBPS(source, cases[1].target)
+	if _, err := applyPatch(cases[2].target, bps); err == nil || !strings.Contains(err.Error(), "another rom") {
+		t.Fatalf("fatal patch error for bps: expected the wrong rom refused, got %v", err)
+	}
+	bps[6] ^= 1
+	if _, err := applyPatch(source, bps); err == nil || !strings.Contains(err.Error(), "damaged") {
+		t.Fatalf("fatal patch error for bps: expected the damaged patch refused, got %v", err)
+	}
+	if _, err := applyPatch(source, []byte("PATCH\x00\x00")); err == nil {
+		t.Fatalf("fatal patch error for ips: expected a cut short patch refused")
+	}
+
+	// ips run length records and truncation, bps copies, as other tools write them
+	ips := []byte("PATCH\x00\x00\x02\x00\x00\x00\x03\xAAEOF\x00\x00\x04")
+	if got, _ := applyPatch([]byte{1, 2, 3, 4, 5, 6}, ips); !bytes.Equal(got, []byte{1, 2, 0xAA, 0xAA}) {
+		t.Fatalf("fatal patch error for ips: expected run length and truncation, got % X", got)
+	}
+	copies := append([]byte{}, bpsMagic...)
+	copies = appendBPSNumber(copies, 4)
+	copies = appendBPSNumber(copies, 6)
+	copies = appendBPSNumber(copies, 0)
+	copies = appendBPSNumber(copies, 1<<2|bpsSourceCopy) // two bytes from source offset 2
+	copies = appendBPSNumber(copies, 2<<1)
+	copies = appendBPSNumber(copies, 3<<2|bpsTargetCopy) // four bytes from target offset 0, overlapping
+	copies = appendBPSNumber(copies, 0)
+	want := []byte{3, 4, 3, 4, 3, 4}
+	copies = appendCRC(copies, crc32.ChecksumIEEE([]byte{1, 2, 3, 4}))
+	copies = appendCRC(copies, crc32.ChecksumIEEE(want))
+	copies = appendCRC(copies, crc32.ChecksumIEEE(copies))
+	if got, err := applyPatch([]byte{1, 2, 3, 4}, copies); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("fatal patch error for bps: expected copies % X, got % X (%v)", want, got, err)
+	}
+}
+
+func TestPatchCmd(t *testing.T) {
+	dir := t.TempDir()
+	original, modified := filepath.Join(dir, "game.ch8"), filepath.Join(dir, "fixed.ch8")
+	if err := ioutil.WriteFile(original, []byte{0x12, 0x00}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(modified, []byte{0x12, 0x02, 0x12, 0x02}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := patchCmd([]string{"create", original, modified}); err != nil {
+		t.Fatal(err)
+	}
+	if err := patchCmd([]string{"apply", original, filepath.Join(dir, "fixed.bps")}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "game-patched.ch8"))
+	if err != nil || !bytes.Equal(got, []byte{0x12, 0x02, 0x12, 0x02}) {
+		t.Fatalf("fatal patch error for apply: expected the fixed rom, got % X (%v)", got, err)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+)
+
+// how a memory byte was used, one bit each
+const (
+	accessExec uint8 = 1 << iota
+	accessRead
+	accessWrite
+)
+
+// a subroutine's share of the run
+type routine struct {
+	calls int // times called
+	self  int // instructions executed in the routine itself
+	total int // instructions executed until it returned, callees included
+}
+
+// a subroutine call in progress
+type call struct {
+	addr  uint16 // routine called
+	start int    // instructions executed before the call
+}
+
+// execution counts gathered while a program runs, through the interpreter
+type profile struct {
+	hits     [4096]int           // instructions executed per address
+	families map[string]int      // instructions executed per opcode family
+	access   [4096]uint8         // access bits per memory byte
+	routines map[uint16]*routine // by entry address
+	calls    []call              // calls in progress, the entry point at the bottom
+	count    int                 // instructions executed
+	selfMods map[[2]uint16]int   // writes into executed code, by writer and address
+	sprites  map[spriteRef]int   // draws per distinct sprite
+
+	// plugins
+	selfModPlugin func(pc, addr uint16) // told of each new write into executed code, if set
+}
+
+func newProfile(entry uint16) *profile {
+	p := &profile{
+		families: map[string]int{},
+		routines: map[uint16]*routine{entry: {calls: 1}},
+	}
+	p.calls = []call{{addr: entry}}
+	return p
+}
+
+// count an instruction about to execute at addr
+func (p *profile) exec(addr uint16, in *instruction) {
+	p.hits[addr&0xFFF]++
+	p.families[in.name]++
+	p.mark(addr, 2, accessExec)
+	p.routines[p.calls[len(p.calls)-1].addr].self++
+	p.count++
+}
+
+// follow calls and returns once an instruction has run
+func (p *profile) executed(in *instruction, opcode uint16) {
+	switch in {
+	case op2NNN:
+		addr := opNNN(opcode)
+		r, ok := p.routines[addr]
+		if !ok {
+			r = &routine{}
+			p.routines[addr] = r
+		}
+		r.calls++
+		p.calls = append(p.calls, call{addr, p.count})
+	case op00EE:
+		// never pop the entry point, whatever the program does
+		if len(p.calls) > 1 {
+			f := p.calls[len(p.calls)-1]
+			p.routines[f.addr].total += p.count - f.start
+			p.calls = p.calls[:len(p.calls)-1]
+		}
+	}
+}
+
+// set access bits on mem[addr:addr+n]
+func (p *profile) mark(addr uint16, n int, bits uint8) {
+	for k := 0; k < n; k++ {
+		p.access[(int(addr)+k)&0xFFF] |= bits
+	}
+}
+
+// note reads and writes made by instructions
+func (c *cpu) profileRead(addr uint16, n int) {
+	if c.prof != nil {
+		c.prof.mark(addr, n, accessRead)
+	}
+}
+
+func (c *cpu) profileWrite(addr uint16, n int) {
+	if c.prof != nil {
+		c.prof.written(c.pc-2, addr, n)
+	}
+}
+
+// close routines still running, so their totals cover the whole run
+// (they count on from here if the run carries on)
+func (p *profile) finish() {
+	for k := range p.calls {
+		f := &p.calls[k]
+		p.routines[f.addr].total += p.count - f.start
+		f.start = p.count
+	}
+}
+
+// distinct addresses executed
+func (p *profile) coverage() int {
+	n := 0
+	for _, h := range p.hits {
+		if h > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// bytes of mem[start:end] executed as part of an instruction
+func (p *profile) executedBytes(start, end int) int {
+	n := 0
+	for a := start; a < end && a < len(p.access); a++ {
+		if p.access[a]&accessExec != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// disassembly of mem[start:end] with hit counts and access bits
+func (p *profile) writeListing(w io.Writer, mem *[4096]uint8, start, end int) {
+	fmt.Fprintf(w, "%-6s %-4s %-4s %-3s %10s %7s  %s\n", "addr", "op", "name", "rwx", "hits", "%", "pseudo")
+	for a := start; a < end; {
+		rwx := []byte("---")
+		for k, bit := range []uint8{accessRead, accessWrite, accessExec} {
+			if p.access[a]&bit != 0 {
+				rwx[k] = "rwx"[k]
+			}
+		}
+
+		// a byte before odd aligned code is data
+		if p.hits[a] == 0 && a+1 < end && p.hits[a+1] > 0 {
+			fmt.Fprintf(w, "0x%03X  %02X   %-4s %s\n", a, mem[a], "", rwx)
+			a++
+			continue
+		}
+		opcode := uint16(mem[a]) << 8
+		if a+1 < len(mem) {
+			opcode |= uint16(mem[a+1])
+		}
+		in := dispatch[opcode]
+		fmt.Fprintf(w, "0x%03X  %04X %-4s %s %10d %6.2f%%  %s\n",
+			a, opcode, in.name, rwx, p.hits[a], percent(p.hits[a], p.count), in.cPseudo)
+		a += 2
+	}
+}
+
+// subroutines by instructions executed in them, most first
+func (p *profile) writeRoutines(w io.Writer, entry uint16) {
+	addrs := make([]uint16, 0, len(p.routines))
+	for addr := range p.routines {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(a, b int) bool {
+		ra, rb := p.routines[addrs[a]], p.routines[addrs[b]]
+		if ra.self != rb.self {
+			return ra.self > rb.self
+		}
+		return addrs[a] < addrs[b]
+	})
+
+	fmt.Fprintf(w, "%-10s %8s %10s %7s %10s %7s\n", "routine", "calls", "self", "%", "total", "%")
+	for _, addr := range addrs {
+		r := p.routines[addr]
+		name := fmt.Sprintf("0x%03X", addr)
+		if addr == entry {
+			name += " main"
+		}
+		fmt.Fprintf(w, "%-10s %8d %10d %6.2f%% %10d %6.2f%%\n",
+			name, r.calls, r.self, percent(r.self, p.count), r.total, percent(r.total, p.count))
+	}
+}
+
+// opcode families by instructions executed, most first
+func (p *profile) writeFamilies(w io.Writer) {
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		if p.families[names[a]] != p.families[names[b]] {
+			return p.families[names[a]] > p.families[names[b]]
+		}
+		return names[a] < names[b]
+	})
+	fmt.Fprintf(w, "%-6s %10s %7s\n", "family", "count", "%")
+	for _, name := range names {
+		fmt.Fprintf(w, "%-6s %10d %6.2f%%\n", name, p.families[name], percent(p.families[name], p.count))
+	}
+}
+
+// writes into executed code
+func writeSelfMods(w io.Writer, mods []selfMod) {
+	fmt.Fprintf(w, "%-6s %-6s %10s\n", "writer", "code", "count")
+	for _, mod := range mods {
+		fmt.Fprintf(w, "0x%03X  0x%03X  %10d\n", mod.PC, mod.Addr, mod.Count)
+	}
+}
+
+// colours for the coverage map, by access bits
+var accessColours = [8]color.RGBA{
+	{0x20, 0x20, 0x20, 0xFF}, // untouched
+	{0x33, 0xFF, 0x33, 0xFF}, // executed
+	{0x33, 0x66, 0xFF, 0xFF}, // read
+	{0x33, 0xFF, 0xFF, 0xFF}, // executed and read
+	{0xFF, 0x33, 0x33, 0xFF}, // written
+	{0xFF, 0xFF, 0x33, 0xFF}, // executed and written
+	{0xFF, 0x33, 0xFF, 0xFF}, // read and written
+	{0xFF, 0xFF, 0xFF, 0xFF}, // all three
+}
+
+// 64x64 image of memory, one pixel per byte row by row, scaled up
+func (p *profile) coverageMap(scale int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64*scale, 64*scale))
+	for a, bits := range p.access {
+		c := accessColours[bits&7]
+		x0, y0 := (a%64)*scale, (a/64)*scale
+		for y := y0; y < y0+scale; y++ {
+			for x := x0; x < x0+scale; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	return img
+}
+
+// chip8 profile [-frames n] [-ipf n] [-quirks name] [-map file.png] [-codemap file] rom
+func profileCmd(args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+	frames := fs.Int("frames", 600, "60Hz frames to run for")
+	ipf := fs.Int("ipf", defaultIPF, "instructions per 60Hz frame")
+	seed := fs.Int64("seed", 1, "random source seed")
+	quirksName := fs.String("quirks", "chip8", "platform quirks: "+strings.Join(quirkPresetNames(), ", "))
+	mapPath := fs.String("map", "", "write a coverage map of memory to this png")
+	scale := fs.Int("scale", 4, "coverage map pixels per byte")
+	codeMapPath := fs.String("codemap", "", "save what ran as code and what was data to this json file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("profile: expected one rom, got %d", fs.NArg())
+	}
+	if *frames < 1 || *ipf < 1 || *scale < 1 {
+		return fmt.Errorf("profile: frames, ipf and scale must be positive")
+	}
+	r, err := loadROM(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	program := r.data
+	q, err := parseQuirks(*quirksName)
+	if err != nil {
+		return err
+	}
+
+	// keep instruction traces out of the run
+	log.SetOutput(ioutil.Discard)
+
+	c := &cpu{
+		rng:           rand.New(rand.NewSource(*seed)),
+		quirks:        q,
+		waitKeyPlugin: headlessWaitKey,
+	}
+	if err := c.init(program); err != nil {
+		return err
+	}
+	c.prof = newProfile(c.layout.entry)
+	for f := 0; f < *frames; f++ {
+		if _, err := c.run(*ipf); err != nil {
+			fmt.Printf("stopped at frame %d: %s\n\n", f, err)
+			break
+		}
+	}
+	c.prof.finish()
+
+	start := int(c.layout.load)
+	fmt.Printf("%d instructions, %d of %d program bytes executed\n\n",
+		c.prof.count, c.prof.executedBytes(start, start+len(program)), len(program))
+	c.prof.writeListing(os.Stdout, &c.mem, start, start+len(program))
+	fmt.Println()
+	c.prof.writeRoutines(os.Stdout, c.layout.entry)
+	fmt.Println()
+	c.prof.writeFamilies(os.Stdout)
+	if mods := c.prof.selfModified(); len(mods) > 0 {
+		fmt.Println()
+		writeSelfMods(os.Stdout, mods)
+	}
+
+	if *codeMapPath != "" {
+		if err := saveCodeMap(*codeMapPath, c.prof.codeMap(program, c.layout.load)); err != nil {
+			return err
+		}
+	}
+	if *mapPath == "" {
+		return nil
+	}
+	f, err := os.Create(*mapPath)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, c.prof.coverageMap(*scale)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
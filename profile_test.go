@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	program := []byte{
+		0xA3, 0x00, // 0x200: i = 0x300
+		0x22, 0x0A, // 0x202: call 0x20A
+		0x22, 0x0A, // 0x204: call 0x20A
+		0x12, 0x06, // 0x206: loop in place
+		0x00,       // 0x208: padding, never run
+		0x00,       // 0x209: padding, never run
+		0x60, 0x07, // 0x20A: v0 = 7
+		0xF0, 0x33, // 0x20C: bcd(v0) at i
+		0xF0, 0x65, // 0x20E: v0 = mem[i]
+		0x00, 0xEE, // 0x210: return
+	}
+	c := &cpu{}
+	if err := c.init(program); err != nil {
+		t.Fatal(err)
+	}
+	c.prof = newProfile(0x200)
+	for k := 0; k < 14; k++ {
+		if err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.prof.finish()
+	p := c.prof
+
+	if p.count != 14 || p.hits[0x20A] != 2 || p.hits[0x206] != 3 || p.hits[0x208] != 0 {
+		t.Fatalf("fatal profile error: unexpected hits, count %d", p.count)
+	}
+	if p.families["2NNN"] != 2 || p.families["1NNN"] != 3 {
+		t.Fatalf("fatal profile error: unexpected families %v", p.families)
+	}
+	sub := p.routines[0x20A]
+	if sub == nil || sub.calls != 2 || sub.self != 8 || sub.total != 8 {
+		t.Fatalf("fatal profile error for 0x20A: expected 2 calls, 8 self and 8 total, got %+v", sub)
+	}
+	if main := p.routines[0x200]; main.self != 6 || main.total != 14 {
+		t.Fatalf("fatal profile error for main: expected 6 self and 14 total, got %+v", main)
+	}
+
+	expected := map[uint16]uint8{
+		0x200: accessExec,
+		0x208: 0,
+		0x300: accessRead | accessWrite,
+		0x302: accessWrite,
+	}
+	for addr, bits := range expected {
+		if p.access[addr] != bits {
+			t.Fatalf("fatal profile error at 0x%X: expected access bits %03b, got %03b", addr, bits, p.access[addr])
+		}
+	}
+	if p.coverage() != 8 || p.executedBytes(0x200, 0x212) != 16 {
+		t.Fatalf("fatal profile error: expected 8 addresses and 16 bytes executed, got %d and %d", p.coverage(), p.executedBytes(0x200, 0x212))
+	}
+
+	out := &bytes.Buffer{}
+	p.writeListing(out, &c.mem, 0x200, 0x212)
+	if !strings.Contains(out.String(), "0x20A  6007 6XKK --x          2") {
+		t.Fatalf("fatal profile error: expected 0x20A listed with 2 hits, got\n%s", out.String())
+	}
+
+	img := p.coverageMap(2)
+	if img.Bounds().Dx() != 128 || img.RGBAAt(0, 16) != accessColours[accessExec] {
+		t.Fatalf("fatal profile error: expected 0x200 drawn as executed in the coverage map")
+	}
+}
+
+func TestProfileRunsOffMemory(t *testing.T) {
+	// i = 0xFFF, then store v0-v2 from there on, past the end of memory
+	c := &cpu{}
+	if err := c.init([]byte{0xAF, 0xFF, 0xF2, 0x55, 0x12, 0x04}); err != nil {
+		t.Fatal(err)
+	}
+	c.prof = newProfile(0x200)
+	if _, err := c.run(10); err == nil || err.Error() != (&trap{"i out of range", 0xFFF}).Error() {
+		t.Fatalf("fatal profile error: expected i out of range, got %v", err)
+	}
+	c.prof.finish()
+	if c.prof.count != 2 || c.prof.hits[0x202] != 1 {
+		t.Fatalf("fatal profile error: expected the run up to the store profiled, got %d instructions", c.prof.count)
+	}
+}
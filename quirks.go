@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// behaviours that differ between chip8 interpreters,
+// the zero value is this emulator's original behaviour
+type quirks struct {
+	shift   bool // 8XY6/8XYE shift v[y] into v[x] instead of shifting v[x]
+	memory  bool // FX55/FX65 leave i one past the last register
+	vfReset bool // 8XY1/8XY2/8XY3 clear v[F]
+	jump    bool // BNNN jumps to v[x] + nnn, x being the top nibble of nnn
+	clip    bool // sprites are cut off at the screen edge instead of wrapping
+	wait    bool // end the frame after each DXYN, like the VIP display wait
+
+	flagLast bool // 8XY4-8XYE write v[F] after the result, so the flag wins when x is F
+}
+
+var quirkPresets = map[string]quirks{
+	"chip8":  {},
+	"vip":    {shift: true, memory: true, vfReset: true, clip: true, wait: true, flagLast: true},
+	"schip":  {jump: true, clip: true, flagLast: true},
+	"xochip": {shift: true, memory: true, flagLast: true},
+	"eti660": {shift: true, memory: true, vfReset: true, clip: true, wait: true, flagLast: true},
+}
+
+// platform names used by the community rom database
+var platformQuirks = map[string]string{
+	"originalChip8": "vip",
+	"hybridVIP":     "vip",
+	"modernChip8":   "chip8",
+	"chip48":        "schip",
+	"superchip1":    "schip",
+	"superchip":     "schip",
+	"xochip":        "xochip",
+}
+
+func quirkPresetNames() []string {
+	names := make([]string, 0, len(quirkPresets))
+	for name := range quirkPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// look up quirks by preset or database platform name
+func parseQuirks(name string) (quirks, error) {
+	if q, ok := quirkPresets[name]; ok {
+		return q, nil
+	}
+	if preset, ok := platformQuirks[name]; ok {
+		return quirkPresets[preset], nil
+	}
+	return quirks{}, fmt.Errorf("unknown quirks preset %q", name)
+}
+
+// switch individual quirks by name, using the community database names
+// shift, memory, logic, jump, wrap and vblank, and octo's vfOrder
+func (q *quirks) set(flags map[string]bool) error {
+	for name, on := range flags {
+		switch name {
+		case "shift":
+			q.shift = on
+		case "memory":
+			q.memory = on
+		case "logic":
+			q.vfReset = on
+		case "jump":
+			q.jump = on
+		case "wrap":
+			q.clip = !on
+		case "vblank":
+			q.wait = on
+		case "vfOrder":
+			q.flagLast = !on
+		default:
+			return fmt.Errorf("unknown quirk %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestQuirks(t *testing.T) {
+	tests := []struct {
+		name    string
+		quirks  quirks
+		program []byte
+		check   func(c *cpu) bool
+	}{
+		{"shift off", quirks{}, []byte{0x60, 0x04, 0x61, 0x03, 0x80, 0x16}, func(c *cpu) bool { return c.v[0] == 0x02 && c.v[0xF] == 0 }},
+		{"shift on", quirks{shift: true}, []byte{0x60, 0x04, 0x61, 0x03, 0x80, 0x16}, func(c *cpu) bool { return c.v[0] == 0x01 && c.v[0xF] == 1 }},
+		{"logic off", quirks{}, []byte{0x6F, 0x01, 0x80, 0x11}, func(c *cpu) bool { return c.v[0xF] == 1 }},
+		{"logic on", quirks{vfReset: true}, []byte{0x6F, 0x01, 0x80, 0x11}, func(c *cpu) bool { return c.v[0xF] == 0 }},
+		{"memory off", quirks{}, []byte{0xA3, 0x00, 0xF2, 0x55}, func(c *cpu) bool { return c.i == 0x300 }},
+		{"memory on", quirks{memory: true}, []byte{0xA3, 0x00, 0xF2, 0x55}, func(c *cpu) bool { return c.i == 0x303 }},
+		{"jump off", quirks{}, []byte{0x60, 0x02, 0x62, 0x04, 0xB2, 0x10}, func(c *cpu) bool { return c.pc == 0x212 }},
+		{"jump on", quirks{jump: true}, []byte{0x60, 0x02, 0x62, 0x04, 0xB2, 0x10}, func(c *cpu) bool { return c.pc == 0x214 }},
+		{"wrap", quirks{}, []byte{0x60, 0x3E, 0xF1, 0x29, 0xD0, 0x15}, func(c *cpu) bool { return c.disp[0][0] == 1 }},
+		{"clip", quirks{clip: true}, []byte{0x60, 0x3E, 0xF1, 0x29, 0xD0, 0x15}, func(c *cpu) bool { return c.disp[0][0] == 0 }},
+		{"flag first", quirks{}, []byte{0x6F, 0x05, 0x61, 0x03, 0x8F, 0x14}, func(c *cpu) bool { return c.v[0xF] == 0x08 }},
+		{"flag last", quirks{flagLast: true}, []byte{0x6F, 0x05, 0x61, 0x03, 0x8F, 0x14}, func(c *cpu) bool { return c.v[0xF] == 0 }},
+	}
+
+	for _, test := range tests {
+		for _, jit := range []bool{false, true} {
+			c := &cpu{quirks: test.quirks}
+			if jit {
+				c.jit = newRecompiler()
+			}
+			// blocks run on to the next jump, so end on a loop in place
+			end := 0x200 + len(test.program)
+			c.init(append(test.program, uint8(0x10|end>>8), uint8(end)))
+			for done := 0; done < len(test.program)/2; {
+				k, err := c.run(1)
+				done += k
+				if err != nil {
+					t.Fatalf("fatal quirks error for %s (recompiler %t): %s", test.name, jit, err)
+				}
+			}
+			if !test.check(c) {
+				t.Fatalf("fatal quirks error for %s (recompiler %t): unexpected state v %v i 0x%X pc 0x%X", test.name, jit, c.v, c.i, c.pc)
+			}
+		}
+	}
+}
+
+func TestParseQuirks(t *testing.T) {
+	q, err := parseQuirks("originalChip8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q != quirkPresets["vip"] {
+		t.Fatalf("fatal quirks error for originalChip8: expected the vip preset, got %+v", q)
+	}
+	if err := q.set(map[string]bool{"wrap": true, "vblank": false, "vfOrder": false}); err != nil {
+		t.Fatal(err)
+	}
+	if q.clip || q.wait || !q.flagLast {
+		t.Fatalf("fatal quirks error for overrides: expected wrapping without vblank, flag last, got %+v", q)
+	}
+	if _, err := parseQuirks("gameboy"); err == nil {
+		t.Fatalf("fatal quirks error for gameboy: expected an error")
+	}
+}
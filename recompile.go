@@ -0,0 +1,210 @@
+package main
+
+// longest run of instructions compiled into a single block
+const maxBlockLen = 64
+
+// compiled basic block
+type block struct {
+	start uint16             // address of the first instruction
+	end   uint16             // address after the last instruction
+	count int                // number of instructions
+	last  *instruction       // terminating instruction, if any
+	op    uint16             // opcode of the terminating instruction
+	run   func(c *cpu) error // chained closures for the whole block
+}
+
+// basic block translator, caches blocks by start address
+type recompiler struct {
+	blocks [4096]*block // compiled blocks by start address
+	code   [4096]bool   // addresses covered by any cached block
+}
+
+func newRecompiler() *recompiler {
+	return &recompiler{}
+}
+
+// run the block at pc, compiling it first if needed,
+// and return the number of instructions executed
+func (r *recompiler) step(c *cpu) (int, error) {
+	// an opcode needs two bytes of memory at pc
+	if int(c.pc)+1 >= len(c.mem) {
+		return 1, &trap{"pc out of range", c.pc}
+	}
+
+	b := r.blocks[c.pc]
+	if b == nil {
+		b = r.compile(c, c.pc)
+	}
+
+	// a fault leaves pc after the instruction that raised it, as the
+	// interpreter does, so the ones run are counted from there
+	if err := b.run(c); err != nil {
+		return int(c.pc-b.start) / 2, err
+	}
+	return b.count, nil
+}
+
+// drop every cached block overlapping mem[addr:addr+n]
+func (r *recompiler) invalidate(addr uint16, n int) {
+	hit := false
+	for a := int(addr); a < int(addr)+n && a < len(r.code); a++ {
+		if r.code[a] {
+			hit = true
+			break
+		}
+	}
+	if !hit {
+		return
+	}
+
+	lo, hi := int(addr), int(addr)+n
+	for start, b := range &r.blocks {
+		if b == nil || int(b.end) <= lo || start >= hi {
+			continue
+		}
+		r.blocks[start] = nil
+		for a := int(b.start); a < int(b.end); a++ {
+			r.code[a] = false
+		}
+	}
+
+	// blocks may share addresses, mark the survivors again
+	for _, b := range &r.blocks {
+		if b == nil {
+			continue
+		}
+		for a := int(b.start); a < int(b.end); a++ {
+			r.code[a] = true
+		}
+	}
+}
+
+// does this instruction end a basic block?
+func endsBlock(in *instruction) bool {
+	switch in {
+	case op00EE, op1NNN, op2NNN, opBNNN, // jumps and calls
+		op3XKK, op4XKK, op5XY0, op9XY0, opEX9E, opEXA1, // skips
+		opDXYN, opFX0A, // display and key wait
+		opFX33, opFX55, // memory writes
+		unknown:
+		return true
+	}
+	return false
+}
+
+// bytes of memory an instruction writes at i
+func writes(in *instruction, opcode uint16) int {
+	switch in {
+	case opFX33:
+		return 3
+	case opFX55:
+		return int(opX(opcode)) + 1
+	}
+	return 0
+}
+
+// translate the straight-line run of instructions at addr
+func (r *recompiler) compile(c *cpu, addr uint16) *block {
+	b := &block{start: addr}
+
+	var steps []func(c *cpu) error
+	var after []uint16 // pc after each step
+	var last func(c *cpu) error
+	pc := addr
+	for int(pc)+1 < len(c.mem) && len(steps) < maxBlockLen {
+		opcode := uint16(c.mem[pc])<<8 | uint16(c.mem[pc+1])
+		in := dispatch[opcode]
+		pc += 2
+		b.count++
+
+		if endsBlock(in) {
+			// terminators see the program counter exactly as the
+			// interpreter would leave it after fetch
+			b.last, b.op = in, opcode
+			next, h := pc, in.exec
+			last = func(c *cpu) error {
+				c.tick()
+				c.pc = next
+				return h(c, opcode)
+			}
+
+			// self-modifying writes drop whatever they touched, from
+			// where i was beforehand since the memory quirk moves it
+			if n := writes(in, opcode); n > 0 {
+				last = func(c *cpu) error {
+					c.tick()
+					c.pc = next
+					addr := c.i
+					err := h(c, opcode)
+					r.invalidate(addr, n)
+					return err
+				}
+			}
+			break
+		}
+		steps = append(steps, translate(in, opcode))
+		after = append(after, pc)
+	}
+	b.end = pc
+
+	// chain the block, straight-line code only touches pc when it fails
+	end := b.end
+	run := last
+	if run == nil {
+		run = func(c *cpu) error {
+			c.pc = end
+			return nil
+		}
+	}
+	for k := len(steps) - 1; k >= 0; k-- {
+		s, next, pc := steps[k], run, after[k]
+		run = func(c *cpu) error {
+			c.tick()
+			if err := s(c); err != nil {
+				c.pc = pc
+				return err
+			}
+			return next(c)
+		}
+	}
+	b.run = run
+
+	r.blocks[addr] = b
+	for a := b.start; a < b.end; a++ {
+		r.code[a] = true
+	}
+	return b
+}
+
+// specialise a straight-line instruction with its operands pre-decoded
+func translate(in *instruction, opcode uint16) func(c *cpu) error {
+	x, y, kk, nnn := opX(opcode), opY(opcode), opKK(opcode), opNNN(opcode)
+	switch in {
+	case op6XKK:
+		return func(c *cpu) error { c.v[x] = kk; return nil }
+	case op7XKK:
+		return func(c *cpu) error { c.v[x] += kk; return nil }
+	case op8XY0:
+		return func(c *cpu) error { c.v[x] = c.v[y]; return nil }
+	case op8XY1:
+		return func(c *cpu) error { c.v[x] |= c.v[y]; c.logicQuirk(); return nil }
+	case op8XY2:
+		return func(c *cpu) error { c.v[x] &= c.v[y]; c.logicQuirk(); return nil }
+	case op8XY3:
+		return func(c *cpu) error { c.v[x] ^= c.v[y]; c.logicQuirk(); return nil }
+	case opANNN:
+		return func(c *cpu) error { c.i = nnn; return nil }
+	case opFX07:
+		return func(c *cpu) error { c.v[x] = c.dt; return nil }
+	case opFX15:
+		return func(c *cpu) error { c.dt = c.v[x]; return nil }
+	case opFX18:
+		return func(c *cpu) error { c.st = c.v[x]; return nil }
+	case opFX1E:
+		return func(c *cpu) error { c.i += uint16(c.v[x]); return nil }
+	}
+
+	// everything else goes through its interpreter handler
+	h := in.exec
+	return func(c *cpu) error { return h(c, opcode) }
+}
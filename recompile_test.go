@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// run the same program through the interpreter and the recompiler
+// and fail on any difference in machine state
+func assertSameAsInterpreter(t *testing.T, program []byte, n int) {
+	interp := &cpu{rng: rand.New(rand.NewSource(1))}
+	interp.init(program)
+	compiled := &cpu{rng: rand.New(rand.NewSource(1)), jit: newRecompiler()}
+	compiled.init(program)
+
+	for done := 0; done < n; {
+		k, errC := compiled.run(1)
+		var errI error
+		for j := 0; j < k; j++ {
+			if errI = interp.step(); errI != nil {
+				break
+			}
+		}
+		done += k
+
+		if (errI == nil) != (errC == nil) {
+			t.Fatalf("error mismatch after %d instructions: interpreter %v, recompiler %v", done, errI, errC)
+		}
+		if interp.pc != compiled.pc || interp.i != compiled.i || interp.sp != compiled.sp ||
+			interp.dt != compiled.dt || interp.st != compiled.st || interp.v != compiled.v ||
+			interp.stack != compiled.stack || interp.mem != compiled.mem || interp.disp != compiled.disp {
+			t.Fatalf("state mismatch after %d instructions at pc 0x%X (recompiler pc 0x%X)", done, interp.pc, compiled.pc)
+		}
+		if errI != nil {
+			return
+		}
+	}
+}
+
+func TestRecompilerPong(t *testing.T) {
+	program, err := ioutil.ReadFile("pong.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameAsInterpreter(t, program, 200000)
+}
+
+func TestRecompilerRunsOffMemory(t *testing.T) {
+	// jump to 0xFFF, where an opcode would need mem[0x1000]
+	program := []byte{0xBF, 0xFF}
+	assertSameAsInterpreter(t, program, 10)
+
+	c := &cpu{jit: newRecompiler()}
+	c.init(program)
+	if _, err := c.run(10); err == nil || err.Error() != "fatal error: pc out of range 0xFFF" {
+		t.Fatalf("fatal recompiler error: expected pc out of range, got %v", err)
+	}
+}
+
+func TestRecompilerTraps(t *testing.T) {
+	cases := []struct {
+		desc    string
+		program []byte
+		kind    string
+	}{
+		{"unknown", []byte{0x60, 0x01, 0x00, 0x01, 0x12, 0x04}, "unknown opcode"},
+		{"load", []byte{0xAF, 0xFF, 0xF1, 0x65, 0x60, 0x05, 0x12, 0x06}, "i out of range"},
+		{"underflow", []byte{0x60, 0x01, 0x00, 0xEE}, "stack underflow"},
+	}
+	for _, tc := range cases {
+		assertSameAsInterpreter(t, tc.program, 10)
+
+		c := &cpu{jit: newRecompiler()}
+		c.init(tc.program)
+		n, err := c.run(10)
+		if tr, ok := err.(*trap); !ok || tr.kind != tc.kind || n != 2 || c.v[0] == 0x05 {
+			t.Fatalf("fatal recompiler error for %s: expected %s after 2 instructions, got %v after %d", tc.desc, tc.kind, err, n)
+		}
+	}
+}
+
+func TestRecompilerSelfModifying(t *testing.T) {
+	program := []byte{
+		0x60, 0x61, // 0x200: v0 = 0x61
+		0x61, 0x05, // 0x202: v1 = 0x05
+		0xA2, 0x0C, // 0x204: i = 0x20C
+		0x71, 0x01, // 0x206: v1 += 1
+		0xF1, 0x55, // 0x208: mem[i:i+1] = v[0:1]
+		0x12, 0x0C, // 0x20A: jump 0x20C
+		0x60, 0x00, // 0x20C: v0 = 0, rewritten to v1 = v1 on every pass
+		0x12, 0x06, // 0x20E: jump 0x206
+	}
+	assertSameAsInterpreter(t, program, 1000)
+}
+
+func benchmarkRun(b *testing.B, jit *recompiler) {
+	program, err := ioutil.ReadFile("pong.ch8")
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := &cpu{jit: jit}
+	c.init(program)
+	b.ResetTimer()
+	for n := 0; n < b.N; {
+		k, err := c.run(1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		n += k
+	}
+}
+
+func BenchmarkInterpreter(b *testing.B) { benchmarkRun(b, nil) }
+func BenchmarkRecompiler(b *testing.B)  { benchmarkRun(b, newRecompiler()) }
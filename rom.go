@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// rom file extensions, with the quirks preset each hints at
+var romExtensions = map[string]string{
+	".ch8": "chip8",
+	".c8":  "chip8",
+	".sc8": "schip",
+	".xo8": "xochip",
+}
+
+// largest file read from an archive, well past any rom or cartridge
+const maxROMSize = 1 << 20
+
+// a rom as loaded, wherever it came from
+type rom struct {
+	name     string // file name of the rom itself, inside any archive
+	data     []byte
+	platform string     // quirks preset hinted by the extension, empty if none
+	cart     *cartridge // the octo cartridge data was assembled from, if any
+}
+
+func newROM(name string, data []byte) *rom {
+	return &rom{name: filepath.Base(name), data: data, platform: romExtensions[strings.ToLower(filepath.Ext(name))]}
+}
+
+// choose one of several roms by name, returning its index
+type romPicker func(names []string) (int, error)
+
+// load a rom file, or a rom from a zip or gzip archive. a zip holding
+// several roms needs one named as archive.zip#name, or else a picker.
+// an octo cartridge comes back assembled, its options kept for resolve.
+func loadROM(path string, pick romPicker) (*rom, error) {
+	r, err := openROM(path, pick)
+	if err != nil || !isCartridge(r.data) {
+		return r, err
+	}
+	if r.cart, err = decodeCartridge(r.data); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if r.data, err = r.cart.assemble(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return r, nil
+}
+
+func openROM(path string, pick romPicker) (*rom, error) {
+	member := ""
+	if k := strings.LastIndexByte(path, '#'); k >= 0 {
+		if _, err := os.Stat(path); err != nil {
+			path, member = path[:k], path[k+1:]
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return loadZipROM(path, data, member, pick)
+	case bytes.HasPrefix(data, []byte{0x1F, 0x8B}):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		data, err := readROM(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		name := r.Name
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		return newROM(name, data), nil
+	}
+	if member != "" {
+		return nil, fmt.Errorf("%s: not an archive", path)
+	}
+	return newROM(path, data), nil
+}
+
+func readROM(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxROMSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxROMSize {
+		return nil, fmt.Errorf("rom larger than %d bytes", maxROMSize)
+	}
+	return data, nil
+}
+
+// the named rom in a zip, its only rom, or the one picked
+func loadZipROM(path string, data []byte, member string, pick romPicker) (*rom, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	var files []*zip.File
+	for _, f := range z.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if member != "" && (f.Name == member || filepath.Base(f.Name) == member) {
+			files = []*zip.File{f}
+			break
+		}
+		if _, ok := romExtensions[strings.ToLower(filepath.Ext(f.Name))]; ok && member == "" {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(a, b int) bool { return files[a].Name < files[b].Name })
+
+	switch {
+	case len(files) == 0 && member != "":
+		return nil, fmt.Errorf("%s: no %s in the archive", path, member)
+	case len(files) == 0:
+		return nil, fmt.Errorf("%s: no roms in the archive", path)
+	case len(files) > 1:
+		names := make([]string, len(files))
+		for k, f := range files {
+			names[k] = f.Name
+		}
+		if pick == nil {
+			return nil, fmt.Errorf("%s: several roms, name one as %s#name: %s", path, path, strings.Join(names, ", "))
+		}
+		k, err := pick(names)
+		if err != nil {
+			return nil, err
+		}
+		files = files[k : k+1]
+	}
+
+	r, err := files[0].Open()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	defer r.Close()
+	data, err = readROM(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s#%s: %s", path, files[0].Name, err)
+	}
+	return newROM(files[0].Name, data), nil
+}
+
+// the program in a rom and its settings: the extension's platform, then
+// the database, then a cartridge's own options, flags named in set winning
+func (db romDB) resolve(r *rom, o *romOptions, set map[string]bool) (program []byte, entry romEntry, known bool, err error) {
+	program = r.data
+	if !set["quirks"] && r.platform != "" {
+		if o.quirks, err = parseQuirks(r.platform); err != nil {
+			return nil, entry, false, err
+		}
+	}
+	entry, known = db.lookup(program)
+	if known {
+		if err := entry.apply(o, set); err != nil {
+			return nil, entry, known, err
+		}
+	}
+	if r.cart != nil {
+		if err := (romEntry{hash: r.name, info: r.cart.info()}).apply(o, set); err != nil {
+			return nil, entry, known, err
+		}
+	}
+	return program, entry, known, nil
+}
+
+// ask on the terminal which rom to run
+func promptROM(names []string) (int, error) {
+	fmt.Fprintln(os.Stderr, "roms in the archive:")
+	for k, name := range names {
+		fmt.Fprintf(os.Stderr, "%3d  %s\n", k+1, name)
+	}
+	fmt.Fprint(os.Stderr, "run which? ")
+	var n int
+	if _, err := fmt.Fscan(os.Stdin, &n); err != nil || n < 1 || n > len(names) {
+		return 0, fmt.Errorf("no rom picked")
+	}
+	return n - 1, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadROM(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	zipped := func(names ...string) []byte {
+		out := &bytes.Buffer{}
+		z := zip.NewWriter(out)
+		for _, name := range names {
+			w, err := z.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(name))
+		}
+		z.Close()
+		return out.Bytes()
+	}
+	gzipped := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzipped)
+	gz.Write([]byte("squashed"))
+	gz.Close()
+	cart, err := newCartridge([]byte{0x12, 0x00}, "loop", romOptions{palette: "green"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gif := &bytes.Buffer{}
+	if err := encodeCartridge(gif, cart); err != nil {
+		t.Fatal(err)
+	}
+
+	write("plain.sc8", []byte("plain"))
+	write("loop.gif", gif.Bytes())
+	write("broken.gif", []byte("GIF89a nonsense"))
+	write("one.zip", zipped("readme.txt", "roms/one.xo8"))
+	write("two.zip", zipped("b.ch8", "a.c8", "notes.txt"))
+	write("game.ch8.gz", gzipped.Bytes())
+
+	first := func(names []string) (int, error) { return 0, nil }
+	cases := []struct {
+		path     string
+		pick     romPicker
+		name     string
+		data     string
+		platform string
+	}{
+		{"plain.sc8", nil, "plain.sc8", "plain", "schip"},
+		{"one.zip", nil, "one.xo8", "roms/one.xo8", "xochip"},
+		{"two.zip#b.ch8", nil, "b.ch8", "b.ch8", "chip8"},
+		{"two.zip#notes.txt", nil, "notes.txt", "notes.txt", ""},
+		{"two.zip", first, "a.c8", "a.c8", "chip8"},
+		{"game.ch8.gz", nil, "game.ch8", "squashed", "chip8"},
+		{"loop.gif", nil, "loop.gif", "\x12\x00", ""},
+		{"broken.gif", nil, "", "cartridge", ""},
+		{"two.zip", nil, "", "several roms", ""},
+		{"two.zip#c.ch8", nil, "", "no c.ch8", ""},
+		{"plain.sc8#x", nil, "", "not an archive", ""},
+	}
+	for _, c := range cases {
+		r, err := loadROM(filepath.Join(dir, c.path), c.pick)
+		if c.name == "" {
+			if err == nil || !strings.Contains(err.Error(), c.data) {
+				t.Fatalf("fatal rom error for %s: expected an error about %s, got %v", c.path, c.data, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("fatal rom error for %s: %s", c.path, err)
+		}
+		if r.name != c.name || string(r.data) != c.data || r.platform != c.platform || (r.cart != nil) != strings.HasSuffix(c.path, ".gif") {
+			t.Fatalf("fatal rom error for %s: expected %s %q %s, got %s %q %s", c.path, c.name, c.data, c.platform, r.name, r.data, r.platform)
+		}
+	}
+}
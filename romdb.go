@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// known roms, in the layout of the community chip-8 database
+//
+//go:embed romdb.json
+var romdbJSON []byte
+
+// a program and every known image of it
+type romProgram struct {
+	Title       string             `json:"title"`
+	Description string             `json:"description,omitempty"`
+	Authors     []string           `json:"authors,omitempty"`
+	Release     string             `json:"release,omitempty"`
+	ROMs        map[string]romInfo `json:"roms"` // by sha1 of the image
+}
+
+// settings for one rom image, empty fields have no opinion
+type romInfo struct {
+	File      string          `json:"file,omitempty"`
+	Platforms []string        `json:"platforms,omitempty"` // most suitable first
+	Quirks    map[string]bool `json:"quirks,omitempty"`    // applied over the platform
+	Tickrate  int             `json:"tickrate,omitempty"`  // instructions per frame
+	Keys      map[string]int  `json:"keys,omitempty"`      // chip8 keys by purpose
+	Colors    *romColors      `json:"colors,omitempty"`
+	FontStyle string          `json:"fontStyle,omitempty"`
+	Filter    string          `json:"filter,omitempty"`
+}
+
+type romColors struct {
+	Pixels []string `json:"pixels"` // unlit then lit, as #RRGGBB
+}
+
+// a rom image with the program it belongs to
+type romEntry struct {
+	hash    string
+	program romProgram
+	info    romInfo
+}
+
+// rom entries by sha1
+type romDB map[string]romEntry
+
+// sha1 of a rom image, as the database keys it
+func romHash(program []byte) string {
+	sum := sha1.Sum(program)
+	return hex.EncodeToString(sum[:])
+}
+
+// default user database, under the user's config directory
+func defaultROMDBPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "chip8", "roms.json")
+}
+
+// the built in database with a user file of the same layout over the top
+// (a missing file at the default path is not an error)
+func loadROMDB(path string) (romDB, error) {
+	db := romDB{}
+	if err := db.add(romdbJSON); err != nil {
+		return nil, fmt.Errorf("romdb: %s", err)
+	}
+	if path == "" {
+		return db, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err) && path == defaultROMDBPath():
+		return db, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := db.add(data); err != nil {
+		return nil, fmt.Errorf("romdb %s: %s", path, err)
+	}
+	return db, nil
+}
+
+// merge a database file, its fields replace any already known
+func (db romDB) add(data []byte) error {
+	var programs []romProgram
+	if err := json.Unmarshal(data, &programs); err != nil {
+		return err
+	}
+	for _, p := range programs {
+		for hash, info := range p.ROMs {
+			hash = strings.ToLower(hash)
+			e, ok := db[hash]
+			if !ok {
+				e = romEntry{hash: hash}
+			}
+			e.program = mergeProgram(e.program, p)
+			e.info = mergeInfo(e.info, info)
+			db[hash] = e
+		}
+	}
+	return nil
+}
+
+func mergeProgram(p, over romProgram) romProgram {
+	if over.Title != "" {
+		p.Title = over.Title
+	}
+	if over.Description != "" {
+		p.Description = over.Description
+	}
+	if over.Authors != nil {
+		p.Authors = over.Authors
+	}
+	if over.Release != "" {
+		p.Release = over.Release
+	}
+	p.ROMs = nil
+	return p
+}
+
+func mergeInfo(info, over romInfo) romInfo {
+	if over.File != "" {
+		info.File = over.File
+	}
+	if over.Platforms != nil {
+		info.Platforms = over.Platforms
+	}
+	if over.Quirks != nil {
+		info.Quirks = over.Quirks
+	}
+	if over.Tickrate != 0 {
+		info.Tickrate = over.Tickrate
+	}
+	if over.Keys != nil {
+		info.Keys = over.Keys
+	}
+	if over.Colors != nil {
+		info.Colors = over.Colors
+	}
+	if over.FontStyle != "" {
+		info.FontStyle = over.FontStyle
+	}
+	if over.Filter != "" {
+		info.Filter = over.Filter
+	}
+	return info
+}
+
+// entry for a rom image, if known
+func (db romDB) lookup(program []byte) (romEntry, bool) {
+	e, ok := db[romHash(program)]
+	return e, ok
+}
+
+// run settings a database entry can supply
+type romOptions struct {
+	quirks  quirks
+	layout  layout
+	font    string
+	ipf     int
+	palette string
+	filter  string
+}
+
+// replace options with the entry's, except those named in set
+// (flags given on the command line always win)
+func (e romEntry) apply(o *romOptions, set map[string]bool) error {
+	if !set["quirks"] && len(e.info.Platforms) > 0 {
+		q, err := parseQuirks(e.info.Platforms[0])
+		if err != nil {
+			return err
+		}
+		o.quirks = q
+	}
+	if !set["layout"] && len(e.info.Platforms) > 0 {
+		l, err := platformLayout(e.info.Platforms[0])
+		if err != nil {
+			return err
+		}
+		o.layout = l
+	}
+	if !set["quirks"] {
+		if err := o.quirks.set(e.info.Quirks); err != nil {
+			return err
+		}
+	}
+	if _, ok := fonts[e.info.FontStyle]; ok && !set["font"] {
+		o.font = e.info.FontStyle
+	}
+	if !set["ipf"] && e.info.Tickrate > 0 {
+		o.ipf = e.info.Tickrate
+	}
+	if !set["palette"] && e.info.Colors != nil {
+		if len(e.info.Colors.Pixels) != 2 {
+			return fmt.Errorf("romdb %s: want two pixel colours, got %d", e.hash, len(e.info.Colors.Pixels))
+		}
+		off := strings.TrimPrefix(e.info.Colors.Pixels[0], "#")
+		on := strings.TrimPrefix(e.info.Colors.Pixels[1], "#")
+		o.palette = off + ":" + on
+	}
+	if !set["filter"] && e.info.Filter != "" {
+		o.filter = e.info.Filter
+	}
+	return nil
+}
+
+// arrow keys for each purpose the database names
+var romKeyArrows = map[string]string{
+	"up":           "up",
+	"down":         "down",
+	"left":         "left",
+	"right":        "right",
+	"player1Up":    "up",
+	"player1Down":  "down",
+	"player1Left":  "left",
+	"player1Right": "right",
+}
+
+// bind the arrow keys to the chip8 keys a rom moves with,
+// unless the keymap already uses them
+func (km *keymap) hint(keys map[string]int) {
+	for purpose, k := range keys {
+		arrow, ok := romKeyArrows[purpose]
+		if !ok || k < 0 || k > 0xF {
+			continue
+		}
+		if _, bound := km.keys[arrow]; bound {
+			continue
+		}
+		if _, bound := km.actions[arrow]; bound {
+			continue
+		}
+		km.keys[arrow] = uint8(k)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestROMDBPong(t *testing.T) {
+	program, err := ioutil.ReadFile("pong.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadROMDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := db.lookup(program)
+	if !ok {
+		t.Fatalf("fatal romdb error for pong.ch8: expected an entry for %s", romHash(program))
+	}
+	if e.program.Title != "Pong" {
+		t.Fatalf("fatal romdb error for pong.ch8: expected title Pong, got %q", e.program.Title)
+	}
+
+	km, err := presetKeymap("qwerty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	km.hint(e.info.Keys)
+	if k, ok := km.key("up"); !ok || k != 0x1 {
+		t.Fatalf("fatal romdb error for pong.ch8: expected up bound to 0x1, got 0x%X (bound %t)", k, ok)
+	}
+	if k, ok := km.key("down"); !ok || k != 0x4 {
+		t.Fatalf("fatal romdb error for pong.ch8: expected down bound to 0x4, got 0x%X (bound %t)", k, ok)
+	}
+}
+
+func TestROMDBPrecedence(t *testing.T) {
+	program := []byte{0x12, 0x00}
+	hash := romHash(program)
+	path := filepath.Join(t.TempDir(), "roms.json")
+	config := `[
+		{"title": "Loop", "roms": {"` + hash + `": {"platforms": ["superchip"], "tickrate": 15, "colors": {"pixels": ["#112233", "#445566"]}}}},
+		{"roms": {"` + hash + `": {"quirks": {"wrap": true}, "filter": "decay"}}}
+	]`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadROMDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := db.lookup(program)
+	if !ok {
+		t.Fatalf("fatal romdb error for user file: expected an entry for %s", hash)
+	}
+
+	// the database replaces defaults
+	o := romOptions{ipf: defaultIPF, palette: "green", filter: "none"}
+	if err := e.apply(&o, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	want := romOptions{quirks: quirks{jump: true, flagLast: true}, layout: standardLayout, ipf: 15, palette: "112233:445566", filter: "decay"}
+	if o != want {
+		t.Fatalf("fatal romdb error for defaults: expected %+v, got %+v", want, o)
+	}
+
+	// flags on the command line replace the database
+	o = romOptions{ipf: 7, palette: "amber", filter: "none"}
+	if err := e.apply(&o, map[string]bool{"quirks": true, "ipf": true, "palette": true, "filter": true}); err != nil {
+		t.Fatal(err)
+	}
+	want = romOptions{layout: standardLayout, ipf: 7, palette: "amber", filter: "none"}
+	if o != want {
+		t.Fatalf("fatal romdb error for flags: expected %+v, got %+v", want, o)
+	}
+
+	// database platform ids pick layouts too, unknown ones are refused
+	cases := []struct {
+		platform string
+		layout   layout
+	}{
+		{"originalChip8", standardLayout},
+		{"superchip", standardLayout},
+		{"eti660", layouts["eti660"]},
+		{"gameboy", layout{}},
+	}
+	for _, tc := range cases {
+		o := romOptions{layout: layout{load: 0x300}}
+		err := romEntry{info: romInfo{Platforms: []string{tc.platform}}}.apply(&o, map[string]bool{"quirks": true})
+		if tc.layout == (layout{}) {
+			if err == nil {
+				t.Fatalf("fatal romdb error for %s: expected an unknown platform refused", tc.platform)
+			}
+			continue
+		}
+		if err != nil || o.layout != tc.layout {
+			t.Fatalf("fatal romdb error for %s: expected layout %+v, got %+v (%v)", tc.platform, tc.layout, o.layout, err)
+		}
+	}
+}
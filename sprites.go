@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// a sprite as DXYN drew it
+type spriteRef struct {
+	addr uint16 // i at the time
+	data string // the n rows read from mem[i:i+n]
+}
+
+// note a DXYN drawing n rows from addr
+func (c *cpu) profileSprite(addr uint16, n uint8) {
+	if c.prof == nil || n == 0 {
+		return
+	}
+	data := make([]byte, n)
+	for k := range data {
+		data[k] = c.mem[(int(addr)+k)&0xFFF]
+	}
+	if c.prof.sprites == nil {
+		c.prof.sprites = map[spriteRef]int{}
+	}
+	c.prof.sprites[spriteRef{addr, string(data)}]++
+}
+
+// a distinct sprite and how often it was drawn
+type spriteUse struct {
+	addr  uint16
+	data  []byte
+	draws int
+}
+
+// sprites drawn, by address then contents
+func (p *profile) drawnSprites() []spriteUse {
+	uses := make([]spriteUse, 0, len(p.sprites))
+	for ref, n := range p.sprites {
+		uses = append(uses, spriteUse{ref.addr, []byte(ref.data), n})
+	}
+	sort.Slice(uses, func(a, b int) bool {
+		if uses[a].addr != uses[b].addr {
+			return uses[a].addr < uses[b].addr
+		}
+		return string(uses[a].data) < string(uses[b].data)
+	})
+	return uses
+}
+
+// rows of a sprite as ascii art
+func spriteRows(data []byte) []string {
+	rows := make([]string, len(data))
+	for k, b := range data {
+		rows[k] = pixels(b)
+	}
+	return rows
+}
+
+// the n bytes at i as ascii art, what DXYN would draw next
+func (c *cpu) spriteAtI(n int) []string {
+	data := make([]byte, n)
+	for k := range data {
+		data[k] = c.mem[(int(c.i)+k)&0xFFF]
+	}
+	return spriteRows(data)
+}
+
+// label for a sprite, numbered when one address held several
+func spriteLabel(uses []spriteUse, k int) string {
+	label := fmt.Sprintf("sprite-%03X", uses[k].addr)
+	if (k > 0 && uses[k-1].addr == uses[k].addr) || (k+1 < len(uses) && uses[k+1].addr == uses[k].addr) {
+		n := 0
+		for j := k - 1; j >= 0 && uses[j].addr == uses[k].addr; j-- {
+			n++
+		}
+		label += fmt.Sprintf("-%d", n)
+	}
+	return label
+}
+
+func writeSpritesASCII(w io.Writer, uses []spriteUse) {
+	for k, u := range uses {
+		fmt.Fprintf(w, "%s: height %d, drawn %d times\n", spriteLabel(uses, k), len(u.data), u.draws)
+		for _, row := range spriteRows(u.data) {
+			fmt.Fprintf(w, "  %s\n", row)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// data listing for octo, one labelled sprite per block
+func writeSpritesOcto(w io.Writer, uses []spriteUse) {
+	for k, u := range uses {
+		fmt.Fprintf(w, ": %s # 0x%03X, height %d, drawn %d times\n", spriteLabel(uses, k), u.addr, len(u.data), u.draws)
+		for _, b := range u.data {
+			fmt.Fprintf(w, "\t0b%08b\n", b)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func rgb(c uint32) color.RGBA {
+	return color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 0xFF}
+}
+
+// a sprite 8 pixels wide, scaled up
+func spriteImage(data []byte, scale int, p palette) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 8*scale, len(data)*scale))
+	for y, b := range data {
+		for x := 0; x < 8; x++ {
+			c := rgb(p.off)
+			if b&(0x80>>uint(x)) != 0 {
+				c = rgb(p.on)
+			}
+			for py := y * scale; py < (y+1)*scale; py++ {
+				for px := x * scale; px < (x+1)*scale; px++ {
+					img.SetRGBA(px, py, c)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// one png per sprite in dir, named by label
+func writeSpritesPNG(dir string, uses []spriteUse, scale int, p palette) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for k, u := range uses {
+		f, err := os.Create(filepath.Join(dir, spriteLabel(uses, k)+".png"))
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, spriteImage(u.data, scale, p)); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chip8 sprites [-frames n] [-format ascii|octo|png] [-o path] rom
+func spritesCmd(args []string) error {
+	fs := flag.NewFlagSet("sprites", flag.ContinueOnError)
+	frames := fs.Int("frames", 600, "60Hz frames to run for")
+	ipf := fs.Int("ipf", defaultIPF, "instructions per 60Hz frame")
+	seed := fs.Int64("seed", 1, "random source seed")
+	quirksName := fs.String("quirks", "chip8", "platform quirks: "+strings.Join(quirkPresetNames(), ", "))
+	format := fs.String("format", "ascii", "output: ascii, octo or png")
+	outPath := fs.String("o", "", "output file, stdout if empty, or the directory for png")
+	scale := fs.Int("scale", 8, "png pixels per sprite pixel")
+	paletteName := fs.String("palette", "green", "png colours: "+strings.Join(paletteNames(), ", ")+" or RRGGBB:RRGGBB")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("sprites: expected one rom, got %d", fs.NArg())
+	}
+	if *frames < 1 || *ipf < 1 || *scale < 1 {
+		return fmt.Errorf("sprites: frames, ipf and scale must be positive")
+	}
+	r, err := loadROM(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	program := r.data
+	q, err := parseQuirks(*quirksName)
+	if err != nil {
+		return err
+	}
+	p, err := parsePalette(*paletteName)
+	if err != nil {
+		return err
+	}
+
+	// keep instruction traces out of the run
+	log.SetOutput(ioutil.Discard)
+
+	c := &cpu{
+		rng:           rand.New(rand.NewSource(*seed)),
+		quirks:        q,
+		waitKeyPlugin: headlessWaitKey,
+	}
+	if err := c.init(program); err != nil {
+		return err
+	}
+	c.prof = newProfile(c.layout.entry)
+	for f := 0; f < *frames; f++ {
+		if _, err := c.run(*ipf); err != nil {
+			fmt.Fprintf(os.Stderr, "stopped at frame %d: %s\n", f, err)
+			break
+		}
+	}
+	uses := c.prof.drawnSprites()
+
+	if *format == "png" {
+		dir := *outPath
+		if dir == "" {
+			dir = "sprites"
+		}
+		return writeSpritesPNG(dir, uses, *scale, p)
+	}
+	out := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	switch *format {
+	case "ascii":
+		writeSpritesASCII(out, uses)
+	case "octo":
+		writeSpritesOcto(out, uses)
+	default:
+		return fmt.Errorf("sprites: unknown format %q", *format)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSprites(t *testing.T) {
+	program := []byte{
+		0xA2, 0x0C, // 0x200: i = 0x20C
+		0xD0, 0x02, // 0x202: draw two rows
+		0xD0, 0x02, // 0x204: and again
+		0x60, 0x7E, // 0x206: v0 = 0x7E
+		0xF0, 0x55, // 0x208: mem[i] = v0
+		0xD0, 0x01, // 0x20A: draw the changed first row
+		0x81, 0xFF, // 0x20C: sprite
+	}
+	c := &cpu{}
+	if err := c.init(program); err != nil {
+		t.Fatal(err)
+	}
+	c.prof = newProfile(0x200)
+	for k := 0; k < 6; k++ {
+		if err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uses := c.prof.drawnSprites()
+	expected := []spriteUse{
+		{0x20C, []byte{0x7E}, 1},
+		{0x20C, []byte{0x81, 0xFF}, 2},
+	}
+	if len(uses) != len(expected) {
+		t.Fatalf("fatal sprites error: expected %d sprites, got %+v", len(expected), uses)
+	}
+	for k := range expected {
+		if uses[k].addr != expected[k].addr || !bytes.Equal(uses[k].data, expected[k].data) || uses[k].draws != expected[k].draws {
+			t.Fatalf("fatal sprites error for %d: expected %+v, got %+v", k, expected[k], uses[k])
+		}
+	}
+	if rows := c.spriteAtI(2); rows[0] != ".######." || rows[1] != "########" {
+		t.Fatalf("fatal sprites error at i: got %v", rows)
+	}
+
+	out := &bytes.Buffer{}
+	writeSpritesOcto(out, uses)
+	listing := ": sprite-20C-0 # 0x20C, height 1, drawn 1 times\n\t0b01111110\n\n" +
+		": sprite-20C-1 # 0x20C, height 2, drawn 2 times\n\t0b10000001\n\t0b11111111\n\n"
+	if out.String() != listing {
+		t.Fatalf("fatal sprites error: expected listing\n%s\ngot\n%s", listing, out.String())
+	}
+
+	img := spriteImage([]byte{0x80}, 2, palette{0x000000, 0x33FF33})
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 2 || img.RGBAAt(1, 1) != rgb(0x33FF33) || img.RGBAAt(2, 0) != rgb(0) {
+		t.Fatalf("fatal sprites error: unexpected image %v", img.Bounds())
+	}
+}
+
+func TestSpritesRunsOffMemory(t *testing.T) {
+	program := []byte{
+		0xA2, 0x0A, // 0x200: i = 0x20A
+		0xD0, 0x01, // 0x202: draw a row
+		0xAF, 0xFF, // 0x204: i = 0xFFF
+		0xF2, 0x55, // 0x206: store v0-v2 past the end of memory
+		0x12, 0x08, // 0x208: never reached
+		0xFF, // 0x20A: sprite
+	}
+	dir := t.TempDir()
+	rom, out := filepath.Join(dir, "runaway.ch8"), filepath.Join(dir, "sprites.txt")
+	if err := ioutil.WriteFile(rom, program, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := spritesCmd([]string{"-o", out, rom}); err != nil {
+		t.Fatal(err)
+	}
+	text, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(text), "sprite-20A: height 1, drawn 1 times") {
+		t.Fatalf("fatal sprites error: expected the sprite drawn before the fault, got %q", text)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// machine state, everything a program can observe
+type state struct {
+	mem   [4096]uint8
+	pc    uint16
+	v     [16]uint8
+	i     uint16
+	dt    uint8
+	st    uint8
+	sp    uint8
+	stack [16]uint16
+	disp  [32][64]uint8
+}
+
+// sha1 of the machine state, equal for machines that behave the same
+func (s state) hash() string {
+	h := sha1.New()
+	// writes to a hash never fail
+	binary.Write(h, binary.BigEndian, s)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// copy out the machine state
+func (c *cpu) snapshot() state {
+	return state{
+		mem:   c.mem,
+		pc:    c.pc,
+		v:     c.v,
+		i:     c.i,
+		dt:    c.dt,
+		st:    c.st,
+		sp:    c.sp,
+		stack: c.stack,
+		disp:  c.disp,
+	}
+}
+
+// replace the machine state, leaving plugins and backends attached
+func (c *cpu) restore(s state) {
+	c.mem = s.mem
+	c.pc = s.pc
+	c.v = s.v
+	c.i = s.i
+	c.dt = s.dt
+	c.st = s.st
+	c.sp = s.sp
+	c.stack = s.stack
+	c.disp = s.disp
+	c.keys = [16]uint8{}
+	c.dirty = allRows
+
+	// compiled blocks may no longer match memory
+	if c.jit != nil {
+		c.jit = newRecompiler()
+	}
+}
+
+// power cycle the machine and load the program again
+func (c *cpu) reset(program []byte) error {
+	c.restore(state{})
+	return c.init(program)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// terminal render modes, by pixels per character cell
+type termMode int
+
+const (
+	termFull    termMode = iota // 1x1, two full blocks per pixel
+	termHalf                    // 1x2, upper and lower half blocks
+	termBraille                 // 2x4, braille dots
+)
+
+func parseTermMode(s string) (mode termMode, auto bool, err error) {
+	switch s {
+	case "auto":
+		return termFull, true, nil
+	case "full":
+		return termFull, false, nil
+	case "half":
+		return termHalf, false, nil
+	case "braille":
+		return termBraille, false, nil
+	}
+	return termFull, false, fmt.Errorf("unknown terminal mode %q", s)
+}
+
+// character cells needed to show a w by h display
+func (m termMode) cells(w, h int) (int, int) {
+	switch m {
+	case termHalf:
+		return w, (h + 1) / 2
+	case termBraille:
+		return (w + 1) / 2, (h + 3) / 4
+	}
+	return 2 * w, h
+}
+
+// most faithful mode that fits a w by h display in the terminal
+func autoTermMode(w, h, termW, termH int) termMode {
+	for _, m := range []termMode{termFull, termHalf} {
+		cw, ch := m.cells(w, h)
+		if cw <= termW && ch <= termH {
+			return m
+		}
+	}
+	return termBraille
+}
+
+// pick the richest colour output the terminal advertises
+func detectOutputMode() termbox.OutputMode {
+	colorterm := os.Getenv("COLORTERM")
+	if colorterm == "truecolor" || colorterm == "24bit" {
+		return termbox.OutputRGB
+	}
+	if strings.Contains(os.Getenv("TERM"), "256color") {
+		return termbox.Output256
+	}
+	return termbox.OutputNormal
+}
+
+func parseOutputMode(s string) (termbox.OutputMode, error) {
+	switch s {
+	case "auto":
+		return detectOutputMode(), nil
+	case "8":
+		return termbox.OutputNormal, nil
+	case "256":
+		return termbox.Output256, nil
+	case "truecolor":
+		return termbox.OutputRGB, nil
+	}
+	return termbox.OutputNormal, fmt.Errorf("unknown colour mode %q", s)
+}
+
+// convert 0xRRGGBB to the nearest attribute in an output mode
+func termColour(rgb uint32, mode termbox.OutputMode) termbox.Attribute {
+	r, g, b := uint8(rgb>>16), uint8(rgb>>8), uint8(rgb)
+	switch mode {
+	case termbox.OutputRGB:
+		return termbox.RGBToAttribute(r, g, b)
+	case termbox.Output256:
+		// nearest entry in the 6x6x6 colour cube, offset past the 16 ansi colours
+		level := func(c uint8) termbox.Attribute {
+			return termbox.Attribute((int(c) + 25) / 51)
+		}
+		return 17 + 36*level(r) + 6*level(g) + level(b)
+	}
+	return termbox.ColorDefault
+}
+
+// render whole frames into the terminal
+type termVideo struct {
+	mode   termMode
+	fg, bg termbox.Attribute
+	text   string // status line under the display
+	shown  string // status line as last drawn
+
+	// plugins
+	setCellPlugin func(x, y int, c rune, fg, bg termbox.Attribute)
+	flushPlugin   func() error
+}
+
+func newTermVideo(mode termMode, p palette, out termbox.OutputMode) *termVideo {
+	v := &termVideo{
+		mode:          mode,
+		fg:            termColour(p.on, out),
+		bg:            termColour(p.off, out),
+		setCellPlugin: termbox.SetCell,
+		flushPlugin:   termbox.Flush,
+	}
+	if out == termbox.OutputNormal {
+		v.fg, v.bg = termbox.ColorGreen, termbox.ColorDefault
+	}
+	return v
+}
+
+// braille dot for each pixel of a 2x4 cell, indexed [y][x]
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// pixel rows covered by each row of cells
+func (m termMode) rowsPerCell() int {
+	switch m {
+	case termHalf:
+		return 2
+	case termBraille:
+		return 4
+	}
+	return 1
+}
+
+func (v *termVideo) present(disp *[32][64]uint8, dirty uint32) error {
+	h, w := len(disp), len(disp[0])
+	lit := func(x, y int) bool {
+		return x < w && y < h && disp[y][x] != 0
+	}
+
+	// redraw only the cell rows that cover a changed pixel row
+	rows := v.mode.rowsPerCell()
+	mask := uint32(1)<<rows - 1
+	cw, ch := v.mode.cells(w, h)
+	for cy := 0; cy < ch; cy++ {
+		if dirty&(mask<<(rows*cy)) == 0 {
+			continue
+		}
+		for cx := 0; cx < cw; cx++ {
+			var r rune
+			switch v.mode {
+			case termFull:
+				r = ' '
+				if lit(cx/2, cy) {
+					r = '█'
+				}
+			case termHalf:
+				top, bottom := lit(cx, 2*cy), lit(cx, 2*cy+1)
+				r = ' '
+				switch {
+				case top && bottom:
+					r = '█'
+				case top:
+					r = '▀'
+				case bottom:
+					r = '▄'
+				}
+			case termBraille:
+				r = 0x2800
+				for dy := 0; dy < 4; dy++ {
+					for dx := 0; dx < 2; dx++ {
+						if lit(2*cx+dx, 4*cy+dy) {
+							r |= brailleDots[dy][dx]
+						}
+					}
+				}
+			}
+			v.setCellPlugin(cx, cy, r, v.fg, v.bg)
+		}
+	}
+
+	// status line on the row below, blanking whatever was longer before
+	if v.text != v.shown {
+		text, old := []rune(v.text), len([]rune(v.shown))
+		for cx := 0; cx < len(text) || cx < old; cx++ {
+			r := ' '
+			if cx < len(text) {
+				r = text[cx]
+			}
+			v.setCellPlugin(cx, ch, r, termbox.ColorDefault, termbox.ColorDefault)
+		}
+		v.shown = v.text
+	}
+	return v.flushPlugin()
+}
+
+// status is drawn with the next frame
+func (v *termVideo) status(text string) error {
+	v.text = text
+	return nil
+}
+
+func (v *termVideo) close() error { return nil }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+// render a display and collect the runes written to each cell
+func mockTermPresent(t *testing.T, mode termMode, disp *[32][64]uint8) map[[2]int]rune {
+	cells := map[[2]int]rune{}
+	v := &termVideo{
+		mode: mode,
+		setCellPlugin: func(x, y int, c rune, fg, bg termbox.Attribute) {
+			cells[[2]int{x, y}] = c
+		},
+		flushPlugin: func() error { return nil },
+	}
+	if err := v.present(disp, allRows); err != nil {
+		t.Fatal(err)
+	}
+	return cells
+}
+
+func TestTermHalf(t *testing.T) {
+	disp := [32][64]uint8{}
+	disp[0][0], disp[1][0] = 1, 1 // both halves
+	disp[0][1] = 1                // upper
+	disp[3][2] = 1                // lower
+	cells := mockTermPresent(t, termHalf, &disp)
+	if len(cells) != 64*16 {
+		t.Fatalf("fatal cell count error: expected %d, got %d", 64*16, len(cells))
+	}
+	expected := map[[2]int]rune{{0, 0}: '█', {1, 0}: '▀', {2, 1}: '▄', {3, 0}: ' '}
+	for cell, r := range expected {
+		if cells[cell] != r {
+			t.Fatalf("fatal half block error at %v: expected %q, got %q", cell, r, cells[cell])
+		}
+	}
+}
+
+func TestTermBraille(t *testing.T) {
+	disp := [32][64]uint8{}
+	disp[0][0], disp[3][1] = 1, 1 // dots 1 and 8 of the first cell
+	disp[5][63] = 1               // dot 5 of the last cell on the second row
+	cells := mockTermPresent(t, termBraille, &disp)
+	if len(cells) != 32*8 {
+		t.Fatalf("fatal cell count error: expected %d, got %d", 32*8, len(cells))
+	}
+	expected := map[[2]int]rune{{0, 0}: 0x2881, {31, 1}: 0x2810, {1, 0}: 0x2800}
+	for cell, r := range expected {
+		if cells[cell] != r {
+			t.Fatalf("fatal braille error at %v: expected %q, got %q", cell, r, cells[cell])
+		}
+	}
+}
+
+func TestAutoTermMode(t *testing.T) {
+	cases := []struct {
+		termW, termH int
+		expected     termMode
+	}{
+		{200, 50, termFull},
+		{100, 40, termHalf},
+		{64, 16, termHalf},
+		{40, 10, termBraille},
+	}
+	for _, tc := range cases {
+		if got := autoTermMode(64, 32, tc.termW, tc.termH); got != tc.expected {
+			t.Fatalf("fatal terminal mode error for %dx%d: expected %d, got %d", tc.termW, tc.termH, tc.expected, got)
+		}
+	}
+}
+
+func TestTermFull(t *testing.T) {
+	disp := [32][64]uint8{}
+	disp[2][5] = 1
+	cells := mockTermPresent(t, termFull, &disp)
+	if len(cells) != 128*32 {
+		t.Fatalf("fatal cell count error: expected %d, got %d", 128*32, len(cells))
+	}
+	if cells[[2]int{10, 2}] != '█' || cells[[2]int{11, 2}] != '█' || cells[[2]int{12, 2}] != ' ' {
+		t.Fatalf("fatal full block error: expected two blocks at (10,2) and (11,2)")
+	}
+}
+
+func TestTermDirtyRows(t *testing.T) {
+	cells := 0
+	v := &termVideo{
+		mode:          termHalf,
+		setCellPlugin: func(x, y int, c rune, fg, bg termbox.Attribute) { cells++ },
+		flushPlugin:   func() error { return nil },
+	}
+
+	// rows 4 and 5 share one cell row, row 31 is in the last
+	disp := [32][64]uint8{}
+	if err := v.present(&disp, 1<<4|1<<5|1<<31); err != nil {
+		t.Fatal(err)
+	}
+	if cells != 2*64 {
+		t.Fatalf("fatal dirty row error: expected %d cells redrawn, got %d", 2*64, cells)
+	}
+}
+
+func TestClearReachesScreen(t *testing.T) {
+	c := &cpu{}
+	c.init([]byte{
+		0xA0, 0x00, // i = sprite 0
+		0xD0, 0x05, // draw it at (0,0)
+		0x00, 0xE0, // clear
+		0x12, 0x06, // loop forever
+	})
+	drawn := map[[2]int]rune{}
+	c.video = &termVideo{
+		mode:          termFull,
+		setCellPlugin: func(x, y int, r rune, fg, bg termbox.Attribute) { drawn[[2]int{x, y}] = r },
+		flushPlugin:   func() error { return nil },
+	}
+
+	// first frame draws the sprite, the second clears it
+	if err := c.frame(2); err != nil {
+		t.Fatal(err)
+	}
+	if drawn[[2]int{0, 0}] != '█' {
+		t.Fatalf("fatal draw error: expected sprite at (0,0), got %q", drawn[[2]int{0, 0}])
+	}
+	if err := c.frame(2); err != nil {
+		t.Fatal(err)
+	}
+	for cell, r := range drawn {
+		if r != ' ' {
+			t.Fatalf("fatal clear error: expected blank screen, got %q at %v", r, cell)
+		}
+	}
+}
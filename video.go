@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// every display row marked dirty
+const allRows = ^uint32(0)
+
+// video backend, shown one complete frame at a time
+type video interface {
+	// show the display, dirty has a bit set for each row changed since
+	// the previous call so backends may redraw only those
+	// (pixels are lit when non-zero, shading backends read 0xFF as full brightness)
+	present(disp *[32][64]uint8, dirty uint32) error
+	close() error
+}
+
+// video backend that can also show a line of emulator status
+type statusVideo interface {
+	status(text string) error // empty text clears it
+}
+
+// colours for unlit and lit pixels, as 0xRRGGBB
+type palette struct {
+	off uint32
+	on  uint32
+}
+
+var palettes = map[string]palette{
+	"green":   {0x000000, 0x33FF33},
+	"amber":   {0x000000, 0xFFB000},
+	"white":   {0x000000, 0xFFFFFF},
+	"inverse": {0xFFFFFF, 0x000000},
+	"lcd":     {0x9BBC0F, 0x0F380F},
+	"blue":    {0x0000AA, 0x55FFFF},
+}
+
+// blend from the unlit to the lit colour by brightness
+func (p palette) shade(level uint8) uint32 {
+	switch level {
+	case 0x00:
+		return p.off
+	case 0xFF:
+		return p.on
+	}
+	var out uint32
+	for shift := uint(0); shift < 24; shift += 8 {
+		off, on := int(p.off>>shift&0xFF), int(p.on>>shift&0xFF)
+		out |= uint32(off+(on-off)*int(level)/0xFF) << shift
+	}
+	return out
+}
+
+func paletteNames() []string {
+	names := make([]string, 0, len(palettes))
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// look up a named palette, or parse a custom one as RRGGBB:RRGGBB
+func parsePalette(s string) (palette, error) {
+	if p, ok := palettes[s]; ok {
+		return p, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return palette{}, fmt.Errorf("unknown palette %q", s)
+	}
+	off, err := strconv.ParseUint(parts[0], 16, 24)
+	if err != nil {
+		return palette{}, fmt.Errorf("bad palette colour %q", parts[0])
+	}
+	on, err := strconv.ParseUint(parts[1], 16, 24)
+	if err != nil {
+		return palette{}, fmt.Errorf("bad palette colour %q", parts[1])
+	}
+	return palette{uint32(off), uint32(on)}, nil
+}
+
+// render the display as a scaled texture in an SDL window
+type sdlVideo struct {
+	window   *sdl.Window
+	renderer *sdl.Renderer
+	texture  *sdl.Texture
+	palette  palette
+	pixels   []byte // ARGB8888 staging buffer for the texture
+	title    string // status shown in the title bar
+}
+
+func newSDLVideo(scale int, p palette, vsync, fullscreen bool) (*sdlVideo, error) {
+	flags := uint32(sdl.WINDOW_SHOWN | sdl.WINDOW_RESIZABLE)
+	if fullscreen {
+		flags |= sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+	window, err := sdl.CreateWindow(
+		"chip8",
+		sdl.WINDOWPOS_UNDEFINED,
+		sdl.WINDOWPOS_UNDEFINED,
+		int32(64*scale),
+		int32(32*scale),
+		flags,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rflags := uint32(sdl.RENDERER_ACCELERATED)
+	if vsync {
+		rflags |= sdl.RENDERER_PRESENTVSYNC
+	}
+	renderer, err := sdl.CreateRenderer(window, -1, rflags)
+	if err != nil {
+		window.Destroy()
+		return nil, err
+	}
+
+	// nearest neighbour, whole multiples only, letterboxed to 2:1
+	sdl.SetHint(sdl.HINT_RENDER_SCALE_QUALITY, "0")
+	renderer.SetLogicalSize(64, 32)
+	renderer.SetIntegerScale(true)
+
+	texture, err := renderer.CreateTexture(sdl.PIXELFORMAT_ARGB8888, sdl.TEXTUREACCESS_STREAMING, 64, 32)
+	if err != nil {
+		renderer.Destroy()
+		window.Destroy()
+		return nil, err
+	}
+
+	return &sdlVideo{
+		window:   window,
+		renderer: renderer,
+		texture:  texture,
+		palette:  p,
+		pixels:   make([]byte, 64*32*4),
+	}, nil
+}
+
+// the texture is small enough to upload whole every frame
+func (v *sdlVideo) present(disp *[32][64]uint8, dirty uint32) error {
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			colour := v.palette.shade(disp[y][x])
+			k := 4 * (64*y + x)
+			v.pixels[k+0] = uint8(colour)       // B
+			v.pixels[k+1] = uint8(colour >> 8)  // G
+			v.pixels[k+2] = uint8(colour >> 16) // R
+			v.pixels[k+3] = 0xFF                // A
+		}
+	}
+	if err := v.texture.Update(nil, v.pixels, 64*4); err != nil {
+		return err
+	}
+
+	// bars around the letterboxed display take the unlit colour
+	off := v.palette.off
+	v.renderer.SetDrawColor(uint8(off>>16), uint8(off>>8), uint8(off), 0xFF)
+	v.renderer.Clear()
+	if err := v.renderer.Copy(v.texture, nil, nil); err != nil {
+		return err
+	}
+	v.renderer.Present()
+	return nil
+}
+
+// status goes in the title bar, out of the way of the display
+func (v *sdlVideo) status(text string) error {
+	if text == v.title {
+		return nil
+	}
+	v.title = text
+	if text == "" {
+		v.window.SetTitle("chip8")
+	} else {
+		v.window.SetTitle("chip8 - " + text)
+	}
+	return nil
+}
+
+func (v *sdlVideo) close() error {
+	v.texture.Destroy()
+	v.renderer.Destroy()
+	return v.window.Destroy()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParsePalette(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected palette
+		ok       bool
+	}{
+		{"green", palettes["green"], true},
+		{"102030:A0B0C0", palette{0x102030, 0xA0B0C0}, true},
+		{"purple", palette{}, false},
+		{"102030:zzzzzz", palette{}, false},
+	}
+	for _, tc := range cases {
+		got, err := parsePalette(tc.in)
+		if (err == nil) != tc.ok {
+			t.Fatalf("fatal palette error for %s: unexpected error %v", tc.in, err)
+		}
+		if got != tc.expected {
+			t.Fatalf("fatal palette error for %s: expected %06X:%06X, got %06X:%06X", tc.in, tc.expected.off, tc.expected.on, got.off, got.on)
+		}
+	}
+}